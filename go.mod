@@ -8,9 +8,11 @@ require (
 	github.com/gophercloud/utils v0.0.0-20210323225332-7b186010c04f
 	github.com/labstack/echo v3.3.10+incompatible
 	github.com/labstack/gommon v0.3.0
-	github.com/michaelbironneau/garbler v0.0.0-20180525195632-2018e2dc9c11
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/pkg/sftp v1.13.0
 	github.com/sirupsen/logrus v1.8.1
+	github.com/stretchr/testify v1.6.1
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/crypto v0.0.0-20210415154028-4f45737414dc
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
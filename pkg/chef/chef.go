@@ -3,25 +3,37 @@ package chef
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
 	"github.com/go-chef/chef"
 	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"text/template"
 )
 
-func New(nodeup nodeup.NodeUP, nodeName string, nodeDomain, chefServerUrl string, validationData []byte, chefValidationPath string, runlist []string) (chef *Chef, err error) {
+// New renders bootstrap files
+// Without validationData client.rb uses node own key from /etc/chef/client.pem
+func New(nodeup nodeup.NodeUP, nodeName string, nodeDomain, chefServerUrl string, validationData []byte, validationKey string, bootstrap *Bootstrap) (chef *Chef, err error) {
 
-	chefConfig, err := createConfig(nodeName, ":auto", "STDOUT", chefServerUrl, "chef-validator")
+	var chefConfig []byte
+	if len(validationData) > 0 {
+		chefConfig, err = createConfig(nodeName, ":auto", "STDOUT", chefServerUrl, "chef-validator", validationKey)
+	} else {
+		chefConfig, err = createClientConfig(nodeName, ":auto", "STDOUT", chefServerUrl)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	hosts, err := createHostFile(nodeName, nodeDomain)
+	hosts, err := CreateHostFile(nodeName, nodeDomain)
 	if err != nil {
 		return nil, err
 	}
 
-	bootstapJson, err := createBootstrapJson(runlist)
+	bootstapJson, err := createBootstrapJson(bootstrap)
 	if err != nil {
 		return
 	}
@@ -36,12 +48,13 @@ func New(nodeup nodeup.NodeUP, nodeName string, nodeDomain, chefServerUrl string
 	return
 }
 
-func createConfig(nodeName string, logLevel string, logLocation string, chefServerUrl string, validationClientName string) ([]byte, error) {
+func createConfig(nodeName string, logLevel string, logLocation string, chefServerUrl string, validationClientName string, validationKey string) ([]byte, error) {
 	config := &Config{
 		LogLevel:             logLevel,
 		LogLocation:          logLocation,
 		ChefServerUrl:        chefServerUrl,
 		ValidationClientName: validationClientName,
+		ValidationKey:        validationKey,
 		NodeName:             nodeName,
 	}
 
@@ -53,7 +66,7 @@ log_location     {{ .LogLocation }}
 chef_server_url  "{{ .ChefServerUrl }}"
 validation_client_name "{{ .ValidationClientName }}"
 node_name "{{ .NodeName }}"
-validation_key "/home/cloud-user/validation.pem"`)
+validation_key "{{ .ValidationKey }}"`)
 	if err != nil {
 		return nil, err
 	}
@@ -64,7 +77,33 @@ validation_key "/home/cloud-user/validation.pem"`)
 	return buf.Bytes(), nil
 }
 
-func createHostFile(nodeName string, domainName string) ([]byte, error) {
+func createClientConfig(nodeName string, logLevel string, logLocation string, chefServerUrl string) ([]byte, error) {
+	config := &Config{
+		LogLevel:      logLevel,
+		LogLocation:   logLocation,
+		ChefServerUrl: chefServerUrl,
+		NodeName:      nodeName,
+	}
+
+	var buf bytes.Buffer
+	t := template.New("client.rb")
+	t, err := t.Parse(`
+log_level        {{ .LogLevel }}
+log_location     {{ .LogLocation }}
+chef_server_url  "{{ .ChefServerUrl }}"
+node_name "{{ .NodeName }}"
+client_key "/etc/chef/client.pem"`)
+	if err != nil {
+		return nil, err
+	}
+	err = t.Execute(&buf, config)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func CreateHostFile(nodeName string, domainName string) ([]byte, error) {
 	hosts := &Hosts{
 		Hostname: nodeName,
 		Domain:   domainName,
@@ -85,14 +124,109 @@ func createHostFile(nodeName string, domainName string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func createBootstrapJson(runlist []string) (j []byte, err error) {
-	j, err = json.Marshal(Bootstrap{runlist})
+func createBootstrapJson(bootstrap *Bootstrap) (j []byte, err error) {
+	// First-boot attributes are saved as node normal attributes
+	data := make(map[string]interface{})
+	for key, value := range bootstrap.Attributes {
+		data[key] = value
+	}
+
+	if bootstrap.PolicyName != "" {
+		data["policy_name"] = bootstrap.PolicyName
+		data["policy_group"] = bootstrap.PolicyGroup
+	} else {
+		data["run_list"] = bootstrap.RunList
+	}
+
+	j, err = json.Marshal(data)
 	if err != nil {
 		return
 	}
 	return
 }
 
+// RunList makes run-list items from role and recipe names
+func RunList(roles []string, recipes []string) []string {
+	var runlist []string
+	for _, role := range roles {
+		runlist = append(runlist, runListItem("role", role))
+	}
+	for _, recipe := range recipes {
+		runlist = append(runlist, runListItem("recipe", recipe))
+	}
+	return runlist
+}
+
+// ParseRunList splits run-list items to role and recipe names
+func ParseRunList(runlist []string) (roles []string, recipes []string) {
+	for _, item := range runlist {
+		switch {
+		case strings.HasPrefix(item, "role[") && strings.HasSuffix(item, "]"):
+			roles = append(roles, item[len("role["):len(item)-1])
+		case strings.HasPrefix(item, "recipe[") && strings.HasSuffix(item, "]"):
+			recipes = append(recipes, item[len("recipe["):len(item)-1])
+		default:
+			recipes = append(recipes, item)
+		}
+	}
+	return
+}
+
+func runListItem(kind string, name string) string {
+	if strings.Contains(name, "[") {
+		return name
+	}
+	return kind + "[" + name + "]"
+}
+
+// LoadAttributes reads first-boot attributes from JSON or YAML file
+func LoadAttributes(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &attributes)
+	default:
+		err = json.Unmarshal(data, &attributes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("attributes file %s: %s", path, err)
+	}
+	return attributes, nil
+}
+
+// SetAttribute sets value by dotted key like nginx.worker_processes
+// JSON values are decoded, anything else is saved as string
+func SetAttribute(attributes map[string]interface{}, key string, value string) error {
+	path := strings.Split(key, ".")
+	for _, name := range path {
+		if name == "" {
+			return fmt.Errorf("attribute key %q is not valid", key)
+		}
+	}
+
+	node := attributes
+	for _, name := range path[:len(path)-1] {
+		child, ok := node[name].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			node[name] = child
+		}
+		node = child
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+		decoded = value
+	}
+	node[path[len(path)-1]] = decoded
+	return nil
+}
+
 func (c *Chef) Log() *logrus.Entry {
 	log := c.Log().WithField("context", "chef")
 	return log
@@ -110,13 +244,138 @@ func NewChefClient(nodeup nodeup.NodeUP, clientName string, key []byte, serverUR
 	}
 
 	c := &ChefClient{
-		nodeup: nodeup,
-		client: client,
+		nodeup:    nodeup,
+		client:    client,
+		serverURL: serverURL,
 	}
 
 	return c, nil
 }
 
+// CreateClient creates API client and returns its private key
+func (c *ChefClient) CreateClient(clientName string) (string, error) {
+	// Key of existing client is unknown, so client is created again on resume
+	if c.isClientExist(clientName) {
+		c.Log().Infof("Recreating chef client %s", clientName)
+		err := c.deleteChefClient(clientName)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	c.Log().Infof("Creating chef client %s", clientName)
+	result, err := c.client.Clients.Create(chef.ApiNewClient{
+		Name:      clientName,
+		CreateKey: true,
+	})
+	if err != nil {
+		c.Log().Errorf("Create chef client error: %s", err)
+		return "", err
+	}
+	if result == nil || result.ChefKey.PrivateKey == "" {
+		return "", fmt.Errorf("chef server returned no private key for client %s", clientName)
+	}
+	return result.ChefKey.PrivateKey, nil
+}
+
+// ValidatorlessBootstrap creates client and node like knife validatorless bootstrap and returns client key.
+// Node is created by the new client, so node ACL lets chef-client save the node
+func (c *ChefClient) ValidatorlessBootstrap(nodeName string, environment string, bootstrap *Bootstrap) (string, error) {
+	clientPem, err := c.CreateClient(nodeName)
+	if err != nil {
+		return "", err
+	}
+
+	// Node left by previous attempt belongs to the recreated client
+	err = c.RemoveNode(nodeName)
+	if err != nil {
+		return "", err
+	}
+
+	nodeClient, err := NewChefClient(c.nodeup, nodeName, []byte(clientPem), c.serverURL)
+	if err != nil {
+		return "", err
+	}
+	err = nodeClient.CreateNode(nodeName, environment, bootstrap)
+	if err != nil {
+		return "", err
+	}
+	return clientPem, nil
+}
+
+// CreateNode creates node with run-list or policy before first chef-client run
+func (c *ChefClient) CreateNode(nodeName string, environment string, bootstrap *Bootstrap) error {
+	c.Log().Infof("Creating chef node %s", nodeName)
+	node := chef.NewNode(nodeName)
+	if environment != "" {
+		node.Environment = environment
+	}
+	node.NormalAttributes = bootstrap.Attributes
+	if bootstrap.PolicyName != "" {
+		node.PolicyName = bootstrap.PolicyName
+		node.PolicyGroup = bootstrap.PolicyGroup
+	} else {
+		node.RunList = bootstrap.RunList
+	}
+
+	var err error
+	if c.isNodeExist(nodeName) {
+		_, err = c.client.Nodes.Put(node)
+	} else {
+		_, err = c.client.Nodes.Post(node)
+	}
+	if err != nil {
+		c.Log().Errorf("Create chef node error: %s", err)
+		return err
+	}
+	return nil
+}
+
+// CheckRole checks role exists on chef server
+func (c *ChefClient) CheckRole(name string) error {
+	_, err := c.client.Roles.Get(name)
+	if err != nil {
+		return fmt.Errorf("chef role %s: %s", name, err)
+	}
+	return nil
+}
+
+// CheckEnvironment checks environment exists on chef server
+func (c *ChefClient) CheckEnvironment(name string) error {
+	_, err := c.client.Environments.Get(name)
+	if err != nil {
+		return fmt.Errorf("chef environment %s: %s", name, err)
+	}
+	return nil
+}
+
+// CheckRecipes returns recipes missing in all cookbooks latest versions
+func (c *ChefClient) CheckRecipes(recipes []string) ([]string, error) {
+	available, err := c.client.Cookbooks.ListAllRecipes()
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, recipe := range recipes {
+		if !containsRecipe(available, recipe) {
+			missing = append(missing, recipe)
+		}
+	}
+	return missing, nil
+}
+
+// Default recipe is listed by cookbook name
+func containsRecipe(available []string, recipe string) bool {
+	recipe = strings.TrimSuffix(recipe, "::default")
+	for _, item := range available {
+		if strings.TrimSuffix(item, "::default") == recipe {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *ChefClient) deleteChefNode(nodeName string) (err error) {
 	c.Log().Infof("Deleting chef node %s", nodeName)
 	err = c.client.Nodes.Delete(nodeName)
@@ -155,16 +414,133 @@ func (c *ChefClient) CleanupNode(nodeName string, clientName string) (status boo
 	return
 }
 
-func (c *ChefClient) isNodeExist(nodeName string) bool {
-	if c.isNodeExist(nodeName) {
-		_, err := c.client.Nodes.Get(nodeName)
-		if err != nil {
-			return false
-		} else {
-			return true
+// RemoveNode deletes node, missing node is not an error
+func (c *ChefClient) RemoveNode(nodeName string) error {
+	if !c.isNodeExist(nodeName) {
+		return nil
+	}
+	return c.deleteChefNode(nodeName)
+}
+
+// RemoveClient deletes API client, missing client is not an error
+func (c *ChefClient) RemoveClient(clientName string) error {
+	if !c.isClientExist(clientName) {
+		return nil
+	}
+	return c.deleteChefClient(clientName)
+}
+
+// OhaiTime returns node last run time, zero if node didn't converge yet
+func (c *ChefClient) OhaiTime(nodeName string) (float64, error) {
+	node, err := c.client.Nodes.Get(nodeName)
+	if err != nil {
+		return 0, err
+	}
+	ohaiTime, _ := node.AutomaticAttributes["ohai_time"].(float64)
+	return ohaiTime, nil
+}
+
+// CheckNode checks node has converged with expected environment and run-list or policy
+func (c *ChefClient) CheckNode(nodeName string, environment string, bootstrap *Bootstrap) error {
+	node, err := c.client.Nodes.Get(nodeName)
+	if err != nil {
+		return fmt.Errorf("chef node %s: %s", nodeName, err)
+	}
+	if ohaiTime, _ := node.AutomaticAttributes["ohai_time"].(float64); ohaiTime == 0 {
+		return fmt.Errorf("chef node %s has not reported", nodeName)
+	}
+
+	if bootstrap.PolicyName != "" {
+		if node.PolicyName != bootstrap.PolicyName || node.PolicyGroup != bootstrap.PolicyGroup {
+			return fmt.Errorf("chef node %s has policy %s/%s, expected %s/%s", nodeName, node.PolicyName, node.PolicyGroup, bootstrap.PolicyName, bootstrap.PolicyGroup)
+		}
+		return nil
+	}
+	if environment != "" && node.Environment != environment {
+		return fmt.Errorf("chef node %s has environment %s, expected %s", nodeName, node.Environment, environment)
+	}
+	if missing := missingItems(node.RunList, bootstrap.RunList); len(missing) > 0 {
+		return fmt.Errorf("chef node %s run-list has no %s", nodeName, strings.Join(missing, ","))
+	}
+	return nil
+}
+
+func missingItems(runlist []string, expected []string) []string {
+	var missing []string
+	for _, item := range expected {
+		found := false
+		for _, nodeItem := range runlist {
+			if nodeItem == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, item)
 		}
 	}
-	return true
+	return missing
+}
+
+// Exists checks node and client on chef server
+func (c *ChefClient) Exists(name string) (node bool, client bool) {
+	return c.isNodeExist(name), c.isClientExist(name)
+}
+
+// NodeNames returns names of all nodes on chef server
+func (c *ChefClient) NodeNames() ([]string, error) {
+	nodes, err := c.client.Nodes.List()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range nodes {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// SearchNodes returns names of nodes with policy, or with all roles in environment
+func (c *ChefClient) SearchNodes(environment string, roles []string, policyName string, policyGroup string) ([]string, error) {
+	var terms []string
+	if policyName != "" {
+		terms = append(terms, "policy_name:"+policyName, "policy_group:"+policyGroup)
+	} else {
+		for _, role := range roles {
+			terms = append(terms, "role:"+role)
+		}
+		if environment != "" {
+			terms = append(terms, "chef_environment:"+environment)
+		}
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("no role, environment or policy to search nodes")
+	}
+
+	result, err := c.client.Search.PartialExec("node", strings.Join(terms, " AND "), map[string]interface{}{
+		"name": []string{"name"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, row := range result.Rows {
+		r, _ := row.(map[string]interface{})
+		data, _ := r["data"].(map[string]interface{})
+		if name, _ := data["name"].(string); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (c *ChefClient) isNodeExist(nodeName string) bool {
+	_, err := c.client.Nodes.Get(nodeName)
+	if err != nil {
+		return false
+	} else {
+		return true
+	}
 }
 
 func (c *ChefClient) isClientExist(clientName string) bool {
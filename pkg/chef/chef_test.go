@@ -1,13 +1,23 @@
 package chef
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"github.com/go-chef/chef"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
 )
 
 func TestCreateConfig(t *testing.T) {
-	r, err := createConfig("test-node", ":auto", "STDOUT", "http://localhost", "chef-validator")
+	r, err := createConfig("test-node", ":auto", "STDOUT", "http://localhost", "chef-validator", "/home/cloud-user/validation.pem")
 	assert.Equal(t, nil, err)
 	testData := `
 log_level        :auto
@@ -20,8 +30,20 @@ validation_key "/home/cloud-user/validation.pem"`
 	assert.Equal(t, testData, string(r))
 }
 
+func TestCreateClientConfig(t *testing.T) {
+	r, err := createClientConfig("test-node", ":auto", "STDOUT", "http://localhost")
+	assert.Equal(t, nil, err)
+	testData := `
+log_level        :auto
+log_location     STDOUT
+chef_server_url  "http://localhost"
+node_name "test-node"
+client_key "/etc/chef/client.pem"`
+	assert.Equal(t, testData, string(r))
+}
+
 func TestCreateHostFile(t *testing.T) {
-	r, err := createHostFile("test", "hostname.example.com")
+	r, err := CreateHostFile("test", "hostname.example.com")
 	assert.Equal(t, nil, err)
 
 	testData := `
@@ -31,8 +53,98 @@ func TestCreateHostFile(t *testing.T) {
 }
 
 func TestCreateBootstrapJson(t *testing.T) {
-	r, err := createBootstrapJson([]string{"role[test]"})
+	r, err := createBootstrapJson(&Bootstrap{RunList: []string{"role[test]"}})
 	assert.Equal(t, nil, err)
 	testData := `{"run_list":["role[test]"]}`
 	assert.Equal(t, testData, string(r))
 }
+
+func TestCreateBootstrapJsonAttributes(t *testing.T) {
+	attributes := map[string]interface{}{}
+	assert.Equal(t, nil, SetAttribute(attributes, "nginx.port", "8080"))
+	assert.Equal(t, nil, SetAttribute(attributes, "nginx.enabled", "true"))
+	assert.Equal(t, nil, SetAttribute(attributes, "app", "search"))
+	assert.NotEqual(t, nil, SetAttribute(attributes, "nginx..port", "1"))
+
+	r, err := createBootstrapJson(&Bootstrap{
+		RunList:    RunList([]string{"base", "search"}, []string{"app::deploy", "recipe[ntp]"}),
+		Attributes: attributes,
+	})
+	assert.Equal(t, nil, err)
+	testData := `{"app":"search","nginx":{"enabled":true,"port":8080},"run_list":["role[base]","role[search]","recipe[app::deploy]","recipe[ntp]"]}`
+	assert.Equal(t, testData, string(r))
+}
+
+func TestCreateBootstrapJsonPolicy(t *testing.T) {
+	r, err := createBootstrapJson(&Bootstrap{PolicyName: "search", PolicyGroup: "production"})
+	assert.Equal(t, nil, err)
+	testData := `{"policy_group":"production","policy_name":"search"}`
+	assert.Equal(t, testData, string(r))
+}
+
+func TestParseRunList(t *testing.T) {
+	roles, recipes := ParseRunList(RunList([]string{"base", "search"}, []string{"app::deploy", "recipe[ntp]"}))
+	assert.Equal(t, []string{"base", "search"}, roles)
+	assert.Equal(t, []string{"app::deploy", "ntp"}, recipes)
+	assert.True(t, containsRecipe([]string{"ntp", "app::deploy"}, "ntp::default"))
+	assert.False(t, containsRecipe([]string{"ntp"}, "app"))
+}
+
+func TestMissingItems(t *testing.T) {
+	runlist := []string{"role[base]", "role[search]", "recipe[ntp]"}
+	assert.Equal(t, []string(nil), missingItems(runlist, []string{"role[search]", "recipe[ntp]"}))
+	assert.Equal(t, []string{"recipe[app::deploy]"}, missingItems(runlist, []string{"role[search]", "recipe[app::deploy]"}))
+}
+
+type testNodeUP struct{}
+
+func (t testNodeUP) Version() string {
+	return "test"
+}
+
+func (t testNodeUP) Log() *logrus.Entry {
+	return logrus.NewEntry(logrus.New())
+}
+
+func testKey(t *testing.T) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Equal(t, nil, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+}
+
+func TestValidatorlessBootstrap(t *testing.T) {
+	adminKey := testKey(t)
+	nodeKey := testKey(t)
+
+	var mutex sync.Mutex
+	requests := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests[r.Method+" "+r.URL.Path] = r.Header.Get("X-Ops-Userid")
+		mutex.Unlock()
+
+		switch r.Method + " " + r.URL.Path {
+		case "POST /clients":
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(chef.ApiClientCreateResult{ChefKey: chef.ChefKey{PrivateKey: nodeKey}})
+		case "POST /nodes":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"uri": "/nodes/search-01"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": ["not found"]}`))
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewChefClient(testNodeUP{}, "admin", []byte(adminKey), server.URL+"/")
+	assert.Equal(t, nil, err)
+
+	clientPem, err := c.ValidatorlessBootstrap("search-01", "production", &Bootstrap{RunList: []string{"role[search]"}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nodeKey, clientPem)
+
+	assert.Equal(t, "admin", requests["POST /clients"])
+	// Node is owned by its own client
+	assert.Equal(t, "search-01", requests["POST /nodes"])
+}
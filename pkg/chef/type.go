@@ -22,6 +22,7 @@ type Config struct {
 	LogLocation          string
 	ChefServerUrl        string
 	ValidationClientName string
+	ValidationKey        string
 	NodeName             string
 }
 type Hosts struct {
@@ -29,13 +30,20 @@ type Hosts struct {
 	Domain   string
 }
 
+// Bootstrap is first-boot data for chef-client -j
+// Policyfile mode with PolicyName and PolicyGroup replaces RunList
 type Bootstrap struct {
-	RunList []string `json:"run_list"`
+	RunList     []string
+	Attributes  map[string]interface{}
+	PolicyName  string
+	PolicyGroup string
 }
 
 type ChefClient struct {
 	nodeup nodeup.NodeUP
 	client *chef.Client
+	// serverURL is used for clients of bootstrapped nodes
+	serverURL string
 
 	log *logrus.Entry
 }
@@ -0,0 +1,107 @@
+package cloudinit
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Markers are printed to console log when bootstrap finishes
+const (
+	DoneMarker   = "nodeup-bootstrap: done"
+	FailedMarker = "nodeup-bootstrap: failed"
+)
+
+const (
+	scriptName = "nodeup-bootstrap.sh"
+	logFile    = "/var/log/nodeup-bootstrap.log"
+)
+
+// Render returns cloud-config document
+func Render(c *Config) ([]byte, error) {
+	if c.Dir == "" {
+		return nil, errors.New("cloud-init bootstrap directory is empty")
+	}
+
+	cc := cloudConfig{
+		Hostname: c.Hostname,
+		FQDN:     c.Hostname + "." + c.Domain,
+	}
+
+	names := make([]string, 0, len(c.Files))
+	for name := range c.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		filePath, permissions := name, "0644"
+		if !path.IsAbs(name) {
+			// Bootstrap files may contain keys
+			filePath, permissions = path.Join(c.Dir, name), "0600"
+		}
+		cc.WriteFiles = append(cc.WriteFiles, newFile(filePath, c.Files[name], permissions))
+	}
+
+	script := path.Join(c.Dir, scriptName)
+	cc.WriteFiles = append(cc.WriteFiles, newFile(script, createScript(c.Dir, c.Commands), "0700"))
+	cc.RunCmd = [][]string{{"bash", script}}
+
+	data, err := yaml.Marshal(cc)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("#cloud-config\n"), data...), nil
+}
+
+// Status looks for bootstrap markers in console log
+func Status(console string) (done bool, err error) {
+	lines := strings.Split(console, "\n")
+	for i, line := range lines {
+		if strings.Contains(line, FailedMarker) {
+			start := i - 50
+			if start < 0 {
+				start = 0
+			}
+			return true, fmt.Errorf("cloud-init bootstrap failed:\n%s", strings.Join(lines[start:i], "\n"))
+		}
+		if strings.Contains(line, DoneMarker) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Commands output goes to log file, markers and log tail on fail go to console
+func createScript(dir string, commands []string) []byte {
+	script := []string{
+		"#!/bin/bash",
+		"cd " + dir,
+		"(",
+		"set -ex",
+	}
+	script = append(script, commands...)
+	script = append(script,
+		") > "+logFile+" 2>&1",
+		"if [ $? -eq 0 ]; then",
+		"  echo '"+DoneMarker+"' > /dev/console",
+		"else",
+		"  (tail -n 50 "+logFile+"; echo '"+FailedMarker+"') > /dev/console",
+		"  exit 1",
+		"fi",
+	)
+	return []byte(strings.Join(script, "\n") + "\n")
+}
+
+func newFile(path string, data []byte, permissions string) writeFile {
+	return writeFile{
+		Path:        path,
+		Content:     base64.StdEncoding.EncodeToString(data),
+		Encoding:    "b64",
+		Permissions: permissions,
+	}
+}
@@ -0,0 +1,73 @@
+package cloudinit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	data, err := Render(&Config{
+		Hostname: "search-ab12c",
+		Domain:   "example.com",
+		Dir:      "/var/lib/nodeup",
+		Files: map[string][]byte{
+			"hosts":                           []byte("127.0.0.1 localhost\n"),
+			"/etc/netplan/00-sc-network.yaml": []byte("network:\n"),
+		},
+		Commands: []string{"sudo mv hosts /etc/hosts"},
+	})
+	assert.Equal(t, nil, err)
+
+	testData := `#cloud-config
+hostname: search-ab12c
+fqdn: search-ab12c.example.com
+write_files:
+  - path: /etc/netplan/00-sc-network.yaml
+    content: bmV0d29yazoK
+    encoding: b64
+    permissions: "0644"
+  - path: /var/lib/nodeup/hosts
+    content: MTI3LjAuMC4xIGxvY2FsaG9zdAo=
+    encoding: b64
+    permissions: "0600"
+  - path: /var/lib/nodeup/nodeup-bootstrap.sh
+    content: IyEvYmluL2Jhc2gKY2QgL3Zhci9saWIvbm9kZXVwCigKc2V0IC1leApzdWRvIG12IGhvc3RzIC9ldGMvaG9zdHMKKSA+IC92YXIvbG9nL25vZGV1cC1ib290c3RyYXAubG9nIDI+JjEKaWYgWyAkPyAtZXEgMCBdOyB0aGVuCiAgZWNobyAnbm9kZXVwLWJvb3RzdHJhcDogZG9uZScgPiAvZGV2L2NvbnNvbGUKZWxzZQogICh0YWlsIC1uIDUwIC92YXIvbG9nL25vZGV1cC1ib290c3RyYXAubG9nOyBlY2hvICdub2RldXAtYm9vdHN0cmFwOiBmYWlsZWQnKSA+IC9kZXYvY29uc29sZQogIGV4aXQgMQpmaQo=
+    encoding: b64
+    permissions: "0700"
+runcmd:
+  - - bash
+    - /var/lib/nodeup/nodeup-bootstrap.sh
+`
+	assert.Equal(t, testData, string(data))
+}
+
+func TestCreateScript(t *testing.T) {
+	testData := `#!/bin/bash
+cd /var/lib/nodeup
+(
+set -ex
+sudo mv hosts /etc/hosts
+) > /var/log/nodeup-bootstrap.log 2>&1
+if [ $? -eq 0 ]; then
+  echo 'nodeup-bootstrap: done' > /dev/console
+else
+  (tail -n 50 /var/log/nodeup-bootstrap.log; echo 'nodeup-bootstrap: failed') > /dev/console
+  exit 1
+fi
+`
+	assert.Equal(t, testData, string(createScript("/var/lib/nodeup", []string{"sudo mv hosts /etc/hosts"})))
+}
+
+func TestStatus(t *testing.T) {
+	done, err := Status("[  OK  ] Started cloud-final\n")
+	assert.Equal(t, false, done)
+	assert.Equal(t, nil, err)
+
+	done, err = Status("Cloud-init v. 19.4 running\nnodeup-bootstrap: done\n")
+	assert.Equal(t, true, done)
+	assert.Equal(t, nil, err)
+
+	done, err = Status("E: Unable to locate package nfs-comon\nnodeup-bootstrap: failed\n")
+	assert.Equal(t, true, done)
+	assert.EqualError(t, err, "cloud-init bootstrap failed:\nE: Unable to locate package nfs-comon")
+}
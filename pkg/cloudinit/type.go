@@ -0,0 +1,27 @@
+package cloudinit
+
+// Config describes host bootstrap passed as user-data
+type Config struct {
+	Hostname string
+	Domain   string
+	// Dir keeps bootstrap files on host
+	Dir string
+	// Files with relative names are written to Dir, absolute paths as is
+	Files map[string][]byte
+	// Commands run from Dir one by one, first failed command stops bootstrap
+	Commands []string
+}
+
+type cloudConfig struct {
+	Hostname   string      `yaml:"hostname"`
+	FQDN       string      `yaml:"fqdn"`
+	WriteFiles []writeFile `yaml:"write_files"`
+	RunCmd     [][]string  `yaml:"runcmd"`
+}
+
+type writeFile struct {
+	Path        string `yaml:"path"`
+	Content     string `yaml:"content"`
+	Encoding    string `yaml:"encoding"`
+	Permissions string `yaml:"permissions"`
+}
@@ -4,15 +4,18 @@ import (
 	"errors"
 	"flag"
 	"github.com/onetwotrip/nodeup/pkg/chef"
+	"github.com/onetwotrip/nodeup/pkg/config"
 	"github.com/onetwotrip/nodeup/pkg/migrate"
 	"github.com/onetwotrip/nodeup/pkg/nodeup"
 	"github.com/onetwotrip/nodeup/pkg/openstack"
 	"github.com/onetwotrip/nodeup/pkg/rebalance"
 	"github.com/onetwotrip/nodeup/pkg/rest"
+	"github.com/onetwotrip/nodeup/pkg/ssh"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
 	"os/user"
+	"path/filepath"
 	"strings"
 )
 
@@ -28,17 +31,22 @@ func Run(version string) {
 	//Connections
 	createConnect(o)
 
+	// HTTP daemon is stopped by signal as before
+	if !o.Daemon {
+		o.HandleSignals()
+	}
+
 	if o.Daemon {
 		rest.Init(o)
 	}
 
 	if o.Migrate {
-		m := migrate.New(*o)
+		m := migrate.New(o)
 		m.Init()
 	}
 
 	if o.Rebalance {
-		r := rebalance.New(*o)
+		r := rebalance.New(o)
 		r.Init()
 	}
 
@@ -83,16 +91,14 @@ func makeLog() *log.Entry {
 func createConnect(o *nodeup.NodeUP) {
 	var err error
 
-	enableChef := true
-	if o.Migrate {
-		enableChef = false
-	}
-	if o.Rebalance {
-		enableChef = false
+	o.Openstack = openstack.New(o, o.OSPublicKey, o.OSKeyName, o.OSFlavorName, o.Image, o.OSRateLimit)
+	if o.SSHBastion != "" {
+		o.Bastion, err = ssh.NewBastion(o, o.SSHBastion, o.SSHUser, o.SSHConfig())
+		if err != nil {
+			o.Log().Fatal(err)
+		}
 	}
-
-	o.Openstack = openstack.New(o, o.OSPublicKey, o.OSKeyName, o.OSFlavorName, o.Image)
-	if enableChef {
+	if chefEnabled(o) {
 		o.Chef, err = chef.NewChefClient(o, o.ChefClientName, o.ChefKeyPem, o.ChefServerUrl)
 		if err != nil {
 			o.Log().Fatal(err)
@@ -101,13 +107,15 @@ func createConnect(o *nodeup.NodeUP) {
 }
 
 func params(o *nodeup.NodeUP) error {
+	var attributes attributeFlags
+	var configPath, profile string
 
 	usr, err := user.Current()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	flag.StringVar(&o.Name, "name", "", "Hostname or  mask like role-environment-* or full-hostname-name if -count 1")
+	flag.StringVar(&o.Name, "name", "", "Hostname or template like {role}-{env}-{seq:03}, {role}-{az}-{rand:5} or role-environment-*")
 	flag.StringVar(&o.Domain, "domain", "", "Domain name like hosts.example.com")
 	flag.StringVar(&o.AvailabilityZone, "availability-zone", "", "Select availability-zone.")
 	flag.StringVar(&o.Image, "image", "Ubuntu 16.04-server (64 bit)", "OS image which will be deployed to a WM(s).")
@@ -116,13 +124,20 @@ func params(o *nodeup.NodeUP) error {
 	flag.StringVar(&o.OSFlavorName, "flavor", "", "Openstack flavor name")
 	flag.StringVar(&o.OSGroupID, "group", "", "Openstack groupID")
 	flag.StringVar(&o.ChefEnvironment, "chefEnvironment", "", "Environment name for host")
-	flag.StringVar(&o.ChefRole, "chefRole", "", "Role name for host")
+	flag.StringVar(&o.ChefRole, "chefRole", "", "Role name for host, comma separated for several roles")
+	flag.StringVar(&o.ChefRecipes, "chefRecipes", "", "Recipes added to run-list like nginx,app::deploy")
+	flag.StringVar(&o.ChefAttributesPath, "chefAttributes", "", "First-boot attributes JSON or YAML file")
+	flag.Var(&attributes, "attr", "First-boot attribute key=value, nested keys like nginx.port=80. Can be repeated")
+	flag.StringVar(&o.ChefPolicyName, "chefPolicyName", "", "Policyfile name instead of -chefRole")
+	flag.StringVar(&o.ChefPolicyGroup, "chefPolicyGroup", "", "Policy group instead of -chefEnvironment")
 	flag.StringVar(&o.OSKeyName, "keyName", usr.Username, "Openstack admin key name")
 	flag.StringVar(&o.OSPublicKeyPath, "publicKeyPath", "", "Openstack admin key path")
 	flag.StringVar(&o.User, "user", "cloud-user", "Openstack user")
 	flag.BoolVar(&o.IgnoreFail, "ignoreFail", false, "Don't delete host after fail")
-	flag.IntVar(&o.Concurrency, "concurrency", 5, "Concurrency bootstrap")
-	flag.IntVar(&o.PrefixCharts, "prefixCharts", 5, "Host mask random prefix")
+	flag.IntVar(&o.Concurrency, "concurrency", 5, "Max hosts bootstrapped, deleted or migrated at once")
+	flag.IntVar(&o.Stagger, "stagger", 0, "Delay (in seconds) between starts of hosts bootstrap, delete or migration")
+	flag.Float64Var(&o.OSRateLimit, "osRateLimit", 0, "Max Openstack API requests per second, 0 is unlimited")
+	flag.IntVar(&o.PrefixCharts, "prefixCharts", 5, "Length of random part for * in -name")
 	flag.IntVar(&o.OSRetryTimeout, "osRetryTimeout", 5, "Timeout (in seconds) for waiting server getting the ACTIVE state")
 	flag.IntVar(&o.SSHWaitRetry, "sshWaitRetry", 20, "SSH Retry count")
 	flag.StringVar(&o.ChefVersion, "chefVersion", "12.20.3", "chef-client version")
@@ -130,90 +145,243 @@ func params(o *nodeup.NodeUP) error {
 	flag.StringVar(&o.ChefClientName, "chefClientName", "", "Chef client name")
 	flag.StringVar(&o.ChefKeyPath, "chefKeyPath", "", "Chef client certificate path")
 	flag.StringVar(&o.ChefValidationPath, "chefValidationPath", "", "Validation key path or CHEF_VALIDATION_PEM")
+	flag.BoolVar(&o.ChefValidatorless, "chefValidatorless", false, "Create node client key via Chef API instead of uploading validation key")
 	flag.StringVar(&o.SSHUser, "sshUser", "cloud-user", "SSH Username")
 	flag.StringVar(&o.SSHUploadDir, "sshUploadDir", "/home/"+o.SSHUser, "SSH Upload directory")
+	flag.StringVar(&o.SSHAuthMethods, "sshAuth", "agent,cert,key", "SSH auth methods in order: agent, cert and key")
+	flag.StringVar(&o.SSHKeyPath, "sshKeyPath", "", "SSH private key for key and cert auth, passphrase from SSH_KEY_PASSPHRASE")
+	flag.StringVar(&o.SSHCertPath, "sshCertPath", "", "SSH user certificate (default -sshKeyPath with -cert.pub suffix)")
+	flag.IntVar(&o.SSHCommandTimeout, "sshCommandTimeout", 60, "Timeout (in minutes) for one bootstrap command, 0 is no timeout")
+	flag.IntVar(&o.SSHCommandRetries, "sshCommandRetries", 2, "Retries for bootstrap commands which are safe to repeat like package install")
+	flag.StringVar(&o.SSHBastion, "sshBastion", "", "SSH jump hosts like user@bastion:22, comma separated for chained jumps")
+	flag.StringVar(&o.SSHHostKeyPolicy, "sshHostKeyPolicy", ssh.PolicyAcceptNew, "SSH host key policy: strict, accept-new or insecure")
+	flag.StringVar(&o.SSHKnownHosts, "sshKnownHosts", filepath.Join(usr.HomeDir, ".ssh", "known_hosts"), "SSH known_hosts file, keys from console log are pinned there")
 	flag.StringVar(&o.DefineNetworks, "networks", "", "Define networks like internet_XX.XX.XX.XX/XX,local_private,global_private")
 	flag.StringVar(&o.WebSSHUser, "web.sshUser", "cloud-user", "SSH User for Web Management")
+	flag.StringVar(&o.JobsDB, "jobsDB", "nodeup.db", "Jobs database path for HTTP daemon")
 
+	flag.StringVar(&o.BootstrapMode, "bootstrap-mode", "ssh", "Bootstrap mode: ssh or cloudinit user-data")
+	flag.IntVar(&o.CloudInitTimeout, "cloudInitTimeout", 30, "Timeout (in minutes) for waiting cloud-init bootstrap")
+	flag.StringVar(&o.Provisioner, "provisioner", "chef", "Host provisioner: chef, shell or ansible")
+	flag.StringVar(&o.ProvisionScriptPath, "provisionScript", "", "Script for shell provisioner")
+	flag.StringVar(&o.AnsibleRepo, "ansibleRepo", "", "Playbooks repository URL for ansible provisioner")
+	flag.StringVar(&o.AnsibleBranch, "ansibleBranch", "master", "Playbooks repository branch for ansible provisioner")
+	flag.StringVar(&o.AnsiblePlaybook, "ansiblePlaybook", "local.yml", "Playbook for ansible provisioner")
+
+	flag.IntVar(&o.ShutdownGrace, "shutdownGrace", 300, "Time (in seconds) for in-flight steps to finish or roll back after SIGINT or SIGTERM")
 	flag.BoolVar(&o.JenkinsMode, "jenkinsMode", false, "Jenkins capability mode")
 
 	flag.StringVar(&o.DeleteNodes, "deleteNodes", "", "Delete mode. Please use -deleteNodes node_name1, node_name2")
+	flag.StringVar(&o.VerifyTCP, "verifyTCP", "", "Ports which should accept connections after bootstrap like 22,443")
+	flag.StringVar(&o.VerifyHTTP, "verifyHTTP", "", "URLs which should answer after bootstrap, empty host is server address like http://:8080/health")
+	flag.StringVar(&o.VerifyCommand, "verifyCommand", "", "Command which should exit zero on host after bootstrap")
+	flag.IntVar(&o.VerifyTimeout, "verifyTimeout", 5, "Timeout (in minutes) for checks after bootstrap")
+	flag.BoolVar(&o.Ensure, "ensure", false, "Create or delete hosts so that -count servers match -name")
+	flag.StringVar(&o.Resume, "resume", "", "Continue failed bootstrap of kept host from the failed step")
 	flag.BoolVar(&o.Daemon, "daemon", false, "Use HTTP daemon")
 
 	flag.BoolVar(&o.Migrate, "migrate", false, "Migrate mode")
 	flag.BoolVar(&o.Rebalance, "rebalance", false, "Rebalance mode")
 	flag.StringVar(&o.Hosts, "hosts", "", "Hosts for migrate")
 	flag.StringVar(&o.Hypervisor, "hypervisor", "", "Migrate to hypervisor")
+	flag.StringVar(&o.PlanOut, "plan-out", "", "Save rebalance plan to file without migration")
+	flag.StringVar(&o.ApplyPlan, "apply-plan", "", "Apply rebalance plan saved by -plan-out")
+
+	flag.BoolVar(&o.DryRun, "dry-run", false, "Print plan without changes in Openstack and Chef")
+	flag.StringVar(&o.PlanFormat, "plan-format", "text", "Plan format: text or json")
+	flag.StringVar(&o.Report, "report", "", "Save run report with result of every host to file")
+	flag.StringVar(&o.ReportFormat, "report-format", "json", "Report format: json or junit")
+
+	flag.StringVar(&configPath, "config", os.Getenv("NODEUP_CONFIG"), "YAML config file with defaults and profiles")
+	flag.StringVar(&profile, "profile", os.Getenv("NODEUP_PROFILE"), "Profile name from -config")
 
 	flag.Parse()
 
+	if configPath != "" {
+		c, err := config.Load(configPath)
+		if err != nil {
+			return err
+		}
+		err = c.Apply(flag.CommandLine, profile)
+		if err != nil {
+			return err
+		}
+	} else if profile != "" {
+		return errors.New("-profile can't be used without -config")
+	}
+
 	o.Gateway = os.Getenv("GATEWAY")
+	o.SSHKeyPassphrase = []byte(os.Getenv("SSH_KEY_PASSPHRASE"))
 
-	enableChef := true
-	if o.Migrate {
-		enableChef = false
+	if o.PlanFormat != "text" && o.PlanFormat != "json" {
+		return errors.New("please provide -plan-format text or json")
 	}
-	if o.Rebalance {
-		enableChef = false
+	if o.ReportFormat != "json" && o.ReportFormat != "junit" {
+		return errors.New("please provide -report-format json or junit")
+	}
+	if o.Concurrency < 1 {
+		return errors.New("please provide -concurrency greater than 0")
+	}
+	if o.VerifyTimeout < 1 {
+		return errors.New("please provide -verifyTimeout greater than 0")
+	}
+	if o.DryRun && o.Daemon {
+		return errors.New("-dry-run can't be used with -daemon")
+	}
+	if o.Resume != "" && (o.DryRun || o.Daemon || o.DeleteNodes != "") {
+		return errors.New("-resume can't be used with -dry-run, -daemon or -deleteNodes")
+	}
+	if o.Ensure && (o.Daemon || o.DeleteNodes != "" || o.Resume != "" || o.Migrate || o.Rebalance) {
+		return errors.New("-ensure can't be used with -daemon, -deleteNodes, -resume, -migrate or -rebalance")
+	}
+	if o.Count < 0 {
+		return errors.New("please provide -count not less than 0")
+	}
+	if o.VerifyCommand != "" && o.BootstrapMode != "ssh" {
+		return errors.New("-verifyCommand can be used with -bootstrap-mode ssh only")
+	}
+	if o.Resume != "" && o.BootstrapMode != "ssh" {
+		return errors.New("-resume can be used with -bootstrap-mode ssh only")
+	}
+	if (o.PlanOut != "" || o.ApplyPlan != "") && !o.Rebalance {
+		return errors.New("-plan-out and -apply-plan can be used with -rebalance only")
+	}
+	if o.PlanOut != "" && o.ApplyPlan != "" {
+		return errors.New("-plan-out can't be used with -apply-plan")
 	}
 
-	if enableChef {
-		if o.ChefValidationPath == "" && len(os.Getenv("CHEF_VALIDATION_PEM")) == 0 {
-			return errors.New("please provide -chefValidationPath or environment variable CHEF_VALIDATION_PEM")
-		} else {
-			if len(os.Getenv("CHEF_VALIDATION_PEM")) > 0 {
-				o.ChefValidationPem = []byte(os.Getenv("CHEF_VALIDATION_PEM"))
-			} else {
-				o.ChefValidationPem, err = ioutil.ReadFile(o.ChefValidationPath)
+	for _, method := range strings.Split(o.SSHAuthMethods, ",") {
+		switch strings.TrimSpace(method) {
+		case ssh.AuthAgent, ssh.AuthCert, ssh.AuthKey:
+		default:
+			return errors.New("please provide -sshAuth with agent, cert or key")
+		}
+	}
+
+	switch o.SSHHostKeyPolicy {
+	case ssh.PolicyStrict, ssh.PolicyAcceptNew, ssh.PolicyInsecure:
+	default:
+		return errors.New("please provide -sshHostKeyPolicy strict, accept-new or insecure")
+	}
+
+	if !o.Migrate && !o.Rebalance {
+		// Resumed host keeps name, run-list and flavor from state
+		create := o.DeleteNodes == "" && o.Resume == "" && !o.Daemon
+
+		if o.BootstrapMode != "ssh" && o.BootstrapMode != "cloudinit" {
+			return errors.New("please provide -bootstrap-mode ssh or cloudinit")
+		}
+
+		switch o.Provisioner {
+		case "chef":
+		case "shell":
+			if o.ProvisionScriptPath == "" && o.DeleteNodes == "" && !o.Daemon {
+				return errors.New("please provide -provisionScript string")
+			}
+			if o.ProvisionScriptPath != "" {
+				o.ProvisionScript, err = ioutil.ReadFile(o.ProvisionScriptPath)
 				if err != nil {
-					o.Log().Errorf("Chef validation read error: %s", err)
+					return err
 				}
 			}
+		case "ansible":
+			if o.AnsibleRepo == "" && o.DeleteNodes == "" && !o.Daemon {
+				return errors.New("please provide -ansibleRepo string")
+			}
+		default:
+			return errors.New("please provide -provisioner chef, shell or ansible")
 		}
-		if o.ChefKeyPath == "" && len(os.Getenv("CHEF_KEY_PEM")) == 0 {
-			return errors.New("please provide -chefKeyPath or environment variable CHEF_KEY_PEM")
-		} else {
-			if len(os.Getenv("CHEF_KEY_PEM")) > 0 {
-				o.ChefKeyPem = []byte(os.Getenv("CHEF_KEY_PEM"))
+
+		if chefEnabled(o) {
+			if o.ChefValidatorless {
+				o.Log().Debug("Validatorless bootstrap, validation key is not used")
+			} else if o.ChefValidationPath == "" && len(os.Getenv("CHEF_VALIDATION_PEM")) == 0 {
+				return errors.New("please provide -chefValidationPath or environment variable CHEF_VALIDATION_PEM")
 			} else {
-				o.ChefKeyPem, err = ioutil.ReadFile(o.ChefKeyPath)
-				if err != nil {
-					o.Log().Errorf("Chef key read error: %s", err)
+				if o.ChefValidationPath != "" {
+					o.ChefValidationPem, err = ioutil.ReadFile(o.ChefValidationPath)
+					if err != nil {
+						o.Log().Errorf("Chef validation read error: %s", err)
+					}
+				} else {
+					o.ChefValidationPem = []byte(os.Getenv("CHEF_VALIDATION_PEM"))
 				}
 			}
-		}
-		if o.ChefServerUrl == "" && len(os.Getenv("CHEF_SERVER_URL")) == 0 {
-			return errors.New("please provide -chefServerUrl or environment variable CHEF_SERVER_URL")
-		} else {
-			if len(os.Getenv("CHEF_SERVER_URL")) > 0 {
-				o.ChefServerUrl = os.Getenv("CHEF_SERVER_URL")
+			if o.ChefKeyPath == "" && len(os.Getenv("CHEF_KEY_PEM")) == 0 {
+				return errors.New("please provide -chefKeyPath or environment variable CHEF_KEY_PEM")
+			} else {
+				if o.ChefKeyPath != "" {
+					o.ChefKeyPem, err = ioutil.ReadFile(o.ChefKeyPath)
+					if err != nil {
+						o.Log().Errorf("Chef key read error: %s", err)
+					}
+				} else {
+					o.ChefKeyPem = []byte(os.Getenv("CHEF_KEY_PEM"))
+				}
+			}
+			if o.ChefServerUrl == "" && len(os.Getenv("CHEF_SERVER_URL")) == 0 {
+				return errors.New("please provide -chefServerUrl or environment variable CHEF_SERVER_URL")
+			} else {
+				if o.ChefServerUrl == "" {
+					o.ChefServerUrl = os.Getenv("CHEF_SERVER_URL")
+				}
 			}
-		}
 
-		if o.ChefClientName == "" && len(os.Getenv("CHEF_CLIENT_NAME")) == 0 {
-			return errors.New("please provide -chefClientName or environment variable CHEF_CLIENT_NAME")
-		} else {
-			if len(os.Getenv("CHEF_CLIENT_NAME")) > 0 {
-				o.ChefClientName = os.Getenv("CHEF_CLIENT_NAME")
+			if o.ChefClientName == "" && len(os.Getenv("CHEF_CLIENT_NAME")) == 0 {
+				return errors.New("please provide -chefClientName or environment variable CHEF_CLIENT_NAME")
+			} else {
+				if o.ChefClientName == "" {
+					o.ChefClientName = os.Getenv("CHEF_CLIENT_NAME")
+				}
 			}
-		}
 
-		if (o.ChefRole == "" && o.DeleteNodes == "") && !o.Daemon {
-			return errors.New("please provide -chefRole string")
-		}
+			policy := o.ChefPolicyName != "" || o.ChefPolicyGroup != ""
+			if policy {
+				if o.ChefPolicyName == "" || o.ChefPolicyGroup == "" {
+					return errors.New("please provide both -chefPolicyName and -chefPolicyGroup")
+				}
+				if o.ChefRole != "" || o.ChefRecipes != "" || o.ChefEnvironment != "" {
+					return errors.New("-chefPolicyName can't be used with -chefRole, -chefRecipes or -chefEnvironment")
+				}
+			}
 
-		if (o.ChefEnvironment == "" && o.DeleteNodes == "") && !o.Daemon {
-			return errors.New("please provide -chefEnvironment string")
+			if o.ChefRole == "" && o.ChefRecipes == "" && !policy && create {
+				return errors.New("please provide -chefRole string")
+			}
+
+			if o.ChefEnvironment == "" && !policy && create {
+				return errors.New("please provide -chefEnvironment string")
+			}
+
+			o.ChefAttributes = make(map[string]interface{})
+			if o.ChefAttributesPath != "" {
+				o.ChefAttributes, err = chef.LoadAttributes(o.ChefAttributesPath)
+				if err != nil {
+					return err
+				}
+			}
+			for _, attribute := range attributes {
+				kv := strings.SplitN(attribute, "=", 2)
+				if len(kv) != 2 {
+					return errors.New("please provide -attr as key=value")
+				}
+				err = chef.SetAttribute(o.ChefAttributes, kv[0], kv[1])
+				if err != nil {
+					return err
+				}
+			}
 		}
-		if (o.Name == "" && o.DeleteNodes == "") && !o.Daemon {
+
+		if o.Name == "" && create {
 			return errors.New("please provide -name string")
 		}
 
-		if (o.Domain == "" && o.DeleteNodes == "") && !o.Daemon {
+		if o.Domain == "" && create {
 			return errors.New("please provide -domain string")
 		}
 
-		if (o.Count == 0 && o.DeleteNodes == "") && !o.Daemon {
+		// -ensure -count 0 removes all hosts matching -name
+		if o.Count == 0 && create && !o.Ensure {
 			return errors.New("please provide -count int")
 		}
 
@@ -231,11 +399,11 @@ func params(o *nodeup.NodeUP) error {
 			}
 		}
 
-		if (o.OSFlavorName == "" && o.DeleteNodes == "") && !o.Daemon {
+		if o.OSFlavorName == "" && create {
 			return errors.New("please provide -flavor string")
 		}
 
-		if (o.OSKeyName == "" && o.DeleteNodes == "") && !o.Daemon {
+		if o.OSKeyName == "" && create {
 			return errors.New("please provide -keyname string")
 		}
 	} else {
@@ -294,3 +462,20 @@ func params(o *nodeup.NodeUP) error {
 
 	return nil
 }
+
+// Chef API is needed for chef provisioner only
+func chefEnabled(o *nodeup.NodeUP) bool {
+	return !o.Migrate && !o.Rebalance && o.Provisioner == "chef"
+}
+
+// Repeated flag values
+type attributeFlags []string
+
+func (a *attributeFlags) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *attributeFlags) Set(value string) error {
+	*a = append(*a, value)
+	return nil
+}
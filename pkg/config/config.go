@@ -0,0 +1,98 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// Flags which can be also set by environment variable
+var FlagEnv = map[string]string{
+	"chefServerUrl":      "CHEF_SERVER_URL",
+	"chefClientName":     "CHEF_CLIENT_NAME",
+	"chefKeyPath":        "CHEF_KEY_PEM",
+	"chefValidationPath": "CHEF_VALIDATION_PEM",
+	"publicKeyPath":      "OS_PUBLIC_KEY",
+}
+
+// Settings without flag, only environment variable
+var Env = map[string]string{
+	"packages": "PACKAGES_TO_INSTALL",
+	"gateway":  "GATEWAY",
+}
+
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	err = yaml.Unmarshal(data, c)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %s", path, err)
+	}
+	return c, nil
+}
+
+// Values returns defaults merged with profile
+func (c *Config) Values(profile string) (map[string]string, error) {
+	values := make(map[string]string)
+	for k, v := range c.Defaults {
+		values[k] = v
+	}
+	if profile == "" {
+		return values, nil
+	}
+	p, ok := c.Profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("profile %s not found in config", profile)
+	}
+	for k, v := range p {
+		values[k] = v
+	}
+	return values, nil
+}
+
+// Apply sets profile values for flags not given in command line
+// and environment variables which are not set
+func (c *Config) Apply(fs *flag.FlagSet, profile string) error {
+	values, err := c.Values(profile)
+	if err != nil {
+		return err
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := values[key]
+		if env, ok := Env[key]; ok {
+			if os.Getenv(env) == "" {
+				os.Setenv(env, value)
+			}
+			continue
+		}
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("unknown config key %s", key)
+		}
+		if given[key] || os.Getenv(FlagEnv[key]) != "" {
+			continue
+		}
+		err = fs.Set(key, value)
+		if err != nil {
+			return fmt.Errorf("config key %s: %s", key, err)
+		}
+	}
+	return nil
+}
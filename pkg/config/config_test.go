@@ -0,0 +1,64 @@
+package config
+
+import (
+	"flag"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"os"
+	"testing"
+)
+
+var testConfig = `
+defaults:
+  image: Ubuntu 18.04
+  networks: local_private
+profiles:
+  search-prod:
+    flavor: 8x16384
+    chefRole: search
+    count: 3
+    chefServerUrl: https://chef.example.com
+    packages: nfs-common
+`
+
+func testFlags() (*flag.FlagSet, map[string]*string) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	values := make(map[string]*string)
+	for _, name := range []string{"image", "networks", "flavor", "chefRole", "count", "chefServerUrl"} {
+		values[name] = fs.String(name, "default", "")
+	}
+	return fs, values
+}
+
+func TestApplyPrecedence(t *testing.T) {
+	c := &Config{}
+	assert.Equal(t, nil, yaml.Unmarshal([]byte(testConfig), c))
+
+	os.Setenv("CHEF_SERVER_URL", "https://env.example.com")
+	os.Setenv("PACKAGES_TO_INSTALL", "")
+	defer os.Unsetenv("CHEF_SERVER_URL")
+	defer os.Unsetenv("PACKAGES_TO_INSTALL")
+
+	fs, values := testFlags()
+	assert.Equal(t, nil, fs.Parse([]string{"-flavor", "4x8192"}))
+	assert.Equal(t, nil, c.Apply(fs, "search-prod"))
+
+	assert.Equal(t, "4x8192", *values["flavor"])
+	assert.Equal(t, "search", *values["chefRole"])
+	assert.Equal(t, "3", *values["count"])
+	assert.Equal(t, "Ubuntu 18.04", *values["image"])
+	assert.Equal(t, "local_private", *values["networks"])
+	assert.Equal(t, "default", *values["chefServerUrl"])
+	assert.Equal(t, "nfs-common", os.Getenv("PACKAGES_TO_INSTALL"))
+}
+
+func TestApplyErrors(t *testing.T) {
+	c := &Config{}
+	assert.Equal(t, nil, yaml.Unmarshal([]byte(testConfig), c))
+
+	fs, _ := testFlags()
+	assert.EqualError(t, c.Apply(fs, "api-staging"), "profile api-staging not found in config")
+
+	c.Defaults["flavour"] = "2x4096"
+	assert.EqualError(t, c.Apply(fs, ""), "unknown config key flavour")
+}
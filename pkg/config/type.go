@@ -0,0 +1,8 @@
+package config
+
+// Config file with defaults and named profiles.
+// Keys are flag names without dash like flavor or chefRole
+type Config struct {
+	Defaults map[string]string            `yaml:"defaults"`
+	Profiles map[string]map[string]string `yaml:"profiles"`
+}
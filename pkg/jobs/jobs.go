@@ -0,0 +1,220 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+	bolt "go.etcd.io/bbolt"
+	"sort"
+	"time"
+)
+
+var jobsBucket = []byte("jobs")
+
+var ErrNotFound = errors.New("job not found")
+
+func New(nodeup nodeup.NodeUP, path string) (*Jobs, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	j := &Jobs{
+		nodeup: nodeup,
+		db:     db,
+	}
+
+	// Jobs still running after restart will never finish
+	err = j.abortRunning()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return j, nil
+}
+
+func (j *Jobs) Close() error {
+	return j.db.Close()
+}
+
+// Create stores new running job
+func (j *Jobs) Create(job *Job) error {
+	if job.ID == "" {
+		job.ID = NewID()
+	}
+	job.State = StateRunning
+	job.ExitStatus = -1
+	job.Started = time.Now()
+	job.History = append(job.History, Event{job.Started, StateRunning, ""})
+
+	j.Log().Infof("Save action %s with id %s for server %s", job.Action, job.ID, job.ServerID)
+	return j.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, job)
+	})
+}
+
+// Update changes job in a single transaction
+func (j *Jobs) Update(id string, fn func(job *Job)) error {
+	return j.db.Update(func(tx *bolt.Tx) error {
+		job, err := get(tx, id)
+		if err != nil {
+			return err
+		}
+		fn(job)
+		return put(tx, job)
+	})
+}
+
+// Event appends message to job history
+func (j *Jobs) Event(id string, message string) error {
+	return j.Update(id, func(job *Job) {
+		job.History = append(job.History, Event{time.Now(), job.State, message})
+	})
+}
+
+// Finish sets job exit status and finish time
+func (j *Jobs) Finish(id string, exitStatus int, message string) error {
+	j.Log().Infof("Finish job %s with exit status %d", id, exitStatus)
+	return j.Update(id, func(job *Job) {
+		finish(job, exitStatus, message)
+	})
+}
+
+func (j *Jobs) Get(id string) (*Job, error) {
+	var job *Job
+	err := j.db.View(func(tx *bolt.Tx) error {
+		var err error
+		job, err = get(tx, id)
+		return err
+	})
+	return job, err
+}
+
+// List returns all jobs, newest first
+func (j *Jobs) List() ([]Job, error) {
+	return j.filter(func(job *Job) bool {
+		return true
+	})
+}
+
+// ListByServer returns server jobs, newest first
+func (j *Jobs) ListByServer(serverID string) ([]Job, error) {
+	return j.filter(func(job *Job) bool {
+		return job.ServerID == serverID
+	})
+}
+
+// Running returns running jobs with action, newest first
+func (j *Jobs) Running(action string) ([]Job, error) {
+	return j.filter(func(job *Job) bool {
+		return job.Action == action && job.State == StateRunning
+	})
+}
+
+// Latest returns last job with action for server
+func (j *Jobs) Latest(serverID string, action string) (*Job, error) {
+	list, err := j.filter(func(job *Job) bool {
+		return job.ServerID == serverID && job.Action == action
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, ErrNotFound
+	}
+	return &list[0], nil
+}
+
+// IsRunning checks running action for server
+func (j *Jobs) IsRunning(serverID string, action string) bool {
+	job, err := j.Latest(serverID, action)
+	if err != nil {
+		return false
+	}
+	return job.State == StateRunning
+}
+
+func (j *Jobs) filter(match func(job *Job) bool) ([]Job, error) {
+	var result []Job
+	err := j.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			if match(&job) {
+				result = append(result, job)
+			}
+			return nil
+		})
+	})
+	sort.Sort(sortedJobs(result))
+	return result, err
+}
+
+func (j *Jobs) abortRunning() error {
+	return j.db.Update(func(tx *bolt.Tx) error {
+		var running []*Job
+		err := tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			if job.State == StateRunning {
+				running = append(running, &job)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, job := range running {
+			j.Log().Warnf("Job %s was interrupted by restart", job.ID)
+			finish(job, 1, "interrupted by daemon restart")
+			if err := put(tx, job); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func finish(job *Job, exitStatus int, message string) {
+	now := time.Now()
+	job.ExitStatus = exitStatus
+	job.Finished = &now
+	if exitStatus == 0 {
+		job.State = StateSuccess
+	} else {
+		job.State = StateFailed
+	}
+	job.History = append(job.History, Event{now, job.State, message})
+}
+
+func get(tx *bolt.Tx, id string) (*Job, error) {
+	data := tx.Bucket(jobsBucket).Get([]byte(id))
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	job := &Job{}
+	err := json.Unmarshal(data, job)
+	return job, err
+}
+
+func put(tx *bolt.Tx, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(jobsBucket).Put([]byte(job.ID), data)
+}
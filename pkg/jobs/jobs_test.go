@@ -0,0 +1,66 @@
+package jobs
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testNodeUP struct{}
+
+func (testNodeUP) Version() string { return "test" }
+
+func (testNodeUP) Log() *logrus.Entry { return logrus.NewEntry(logrus.New()) }
+
+func TestJobsLifecycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nodeup.db")
+
+	j, err := New(testNodeUP{}, path)
+	assert.Equal(t, nil, err)
+
+	first := &Job{Action: "chef", ServerID: "server-1", User: "ops"}
+	assert.Equal(t, nil, j.Create(first))
+	assert.True(t, j.IsRunning("server-1", "chef"))
+	assert.Equal(t, nil, j.Finish(first.ID, 0, ""))
+	assert.False(t, j.IsRunning("server-1", "chef"))
+
+	second := &Job{Action: "chef", ServerID: "server-1", User: "ops"}
+	assert.Equal(t, nil, j.Create(second))
+	assert.Equal(t, nil, j.Create(&Job{Action: "stop", ServerID: "server-2"}))
+
+	list, err := j.ListByServer("server-1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, second.ID, list[0].ID)
+
+	list, err = j.Running("chef")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, second.ID, list[0].ID)
+
+	job, err := j.Get(first.ID)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, StateSuccess, job.State)
+	assert.Equal(t, 2, len(job.History))
+	assert.NotNil(t, job.Finished)
+
+	// Running jobs are failed after restart
+	assert.Equal(t, nil, j.Close())
+	j, err = New(testNodeUP{}, path)
+	assert.Equal(t, nil, err)
+	defer j.Close()
+
+	job, err = j.Get(second.ID)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, StateFailed, job.State)
+	assert.Equal(t, 1, job.ExitStatus)
+
+	_, err = j.Get("unknown")
+	assert.Equal(t, ErrNotFound, err)
+}
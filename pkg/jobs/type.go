@@ -0,0 +1,44 @@
+package jobs
+
+import (
+	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+// State:
+// running - In progress
+// success - Done with ok
+// failed - Done with error
+const (
+	StateRunning = "running"
+	StateSuccess = "success"
+	StateFailed  = "failed"
+)
+
+type Jobs struct {
+	nodeup nodeup.NodeUP
+	db     *bolt.DB
+}
+
+type Job struct {
+	ID         string     `json:"id"`
+	Action     string     `json:"action"`
+	ServerID   string     `json:"server_id,omitempty"`
+	Hostname   string     `json:"hostname,omitempty"`
+	User       string     `json:"user"`
+	State      string     `json:"state"`
+	ExitStatus int        `json:"exit_status"`
+	LogFile    string     `json:"log_file,omitempty"`
+	Started    time.Time  `json:"started"`
+	Finished   *time.Time `json:"finished,omitempty"`
+	History    []Event    `json:"history"`
+}
+
+type Event struct {
+	Time    time.Time `json:"time"`
+	State   string    `json:"state"`
+	Message string    `json:"message,omitempty"`
+}
+
+type sortedJobs []Job
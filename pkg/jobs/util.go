@@ -0,0 +1,27 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+func (j *Jobs) Log() *logrus.Entry {
+	log := j.nodeup.Log().WithField("context", "jobs")
+	return log
+}
+
+// NewID generates unique job ID
+func NewID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (s sortedJobs) Len() int           { return len(s) }
+func (s sortedJobs) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sortedJobs) Less(i, j int) bool { return s[i].Started.After(s[j].Started) }
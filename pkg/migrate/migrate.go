@@ -2,14 +2,13 @@ package migrate
 
 import (
 	"github.com/onetwotrip/nodeup/pkg/nodeup"
+	"github.com/onetwotrip/nodeup/pkg/plan"
 	"os"
-	"os/signal"
 	"strings"
 	"sync"
-	"syscall"
 )
 
-func New(nodeup nodeup.NodeUP) *Migrate {
+func New(nodeup *nodeup.NodeUP) *Migrate {
 	m := &Migrate{
 		nodeup: nodeup,
 	}
@@ -20,22 +19,17 @@ func (m *Migrate) Init() {
 
 	m.nodeup.Exitcode = 0
 
-	// handle sigterm correctly
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		s := <-c
-		logger := m.Log().WithField("signal", s.String())
-		logger.Debug("received signal")
-		m.nodeup.Stop()
-	}()
-
 	m.Log().Infof("NodeUP %s starting", m.nodeup.Ver)
 	m.Log().Info("Migration mode enabled")
 	m.Log().Infof("Hosts for migration to hypervisor %s: %s", m.nodeup.Hypervisor, m.nodeup.Hosts)
 
-	var wg sync.WaitGroup
+	if m.nodeup.DryRun {
+		m.nodeup.PrintPlan(m.plan())
+		os.Exit(0)
+	}
 
+	var mutex sync.Mutex
+	p := m.nodeup.NewPool()
 	for _, host := range strings.Split(m.nodeup.DeleteWhitespaces(m.nodeup.Hosts), ",") {
 		m.Log().Infof("Searching ID for host %s", host)
 		hostID, err := m.nodeup.Openstack.IDFromName(host)
@@ -44,15 +38,43 @@ func (m *Migrate) Init() {
 		}
 		m.Log().Debugf("HostID for host %s: %s", host, hostID)
 
-		m.Log().Debugf("Starting goroutine for host %s", host)
-		wg.Add(1)
-		go func(hostID string) {
-			if !m.nodeup.Openstack.MigrateHost(hostID, m.nodeup.Hypervisor, &wg) {
+		m.Log().Debugf("Starting worker for host %s", host)
+		started := p.Go(func() {
+			if !m.nodeup.Openstack.MigrateHost(m.nodeup.Context(), hostID, m.nodeup.Hypervisor) {
+				mutex.Lock()
 				m.nodeup.Exitcode = 1
+				mutex.Unlock()
 			}
-		}(hostID)
+		})
+		if !started {
+			break
+		}
+	}
+	p.Wait()
+	if m.nodeup.Stopped() {
+		m.Log().Error("Migration was interrupted")
+		m.nodeup.Exitcode = 1
 	}
-	m.Log().Debug("Waiting for workers to finish")
-	wg.Wait()
 	os.Exit(m.nodeup.Exitcode)
 }
+
+func (m *Migrate) plan() *plan.Plan {
+	p := plan.New("migrate")
+	for _, host := range strings.Split(m.nodeup.DeleteWhitespaces(m.nodeup.Hosts), ",") {
+		hostID, err := m.nodeup.Openstack.IDFromName(host)
+		if err != nil {
+			m.Log().Fatal(err)
+		}
+		server, err := m.nodeup.Openstack.GetServerDetail(hostID)
+		if err != nil {
+			m.Log().Fatal(err)
+		}
+		p.Migrate = append(p.Migrate, plan.Move{
+			Server:   host,
+			ServerID: hostID,
+			From:     server.HypervisorName,
+			To:       m.nodeup.Hypervisor,
+		})
+	}
+	return p
+}
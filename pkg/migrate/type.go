@@ -6,6 +6,6 @@ import (
 )
 
 type Migrate struct {
-	nodeup nodeup.NodeUP
+	nodeup *nodeup.NodeUP
 	log    *logrus.Entry
 }
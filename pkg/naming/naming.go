@@ -0,0 +1,222 @@
+package naming
+
+import (
+	"fmt"
+	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Characters of {rand:N} placeholder
+const randomChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// Attempts to find unused random name for one host
+const randomAttempts = 100
+
+var placeholder = regexp.MustCompile(`\{([a-z]+)(?::([0-9]+))?\}|\*`)
+
+func New(nodeup nodeup.NodeUP, config Config) *Naming {
+	return &Naming{
+		nodeup: nodeup,
+		config: config,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Generate returns count hostnames from template which are not in existing.
+// {seq} continues from the highest index of existing names matching template
+func (n *Naming) Generate(template string, count int, existing []string) ([]string, error) {
+	parts, err := n.parse(template)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		used[name] = true
+	}
+
+	seq := has(parts, partSeq)
+	if !seq && !has(parts, partRand) && count > 1 {
+		return nil, fmt.Errorf("hostname %s has no {seq} or {rand:N}, please set -count 1", template)
+	}
+
+	next := 1
+	if seq {
+		next = maxIndex(parts, existing) + 1
+	}
+
+	var result []string
+	for len(result) < count {
+		name, err := n.unused(parts, next, used)
+		if err != nil {
+			return nil, err
+		}
+		n.Log().Debugf("Hostname %s generated from %s", name, template)
+		used[name] = true
+		result = append(result, name)
+		next++
+	}
+	return result, nil
+}
+
+// Match returns names which could be generated from template
+func (n *Naming) Match(template string, names []string) ([]string, error) {
+	parts, err := n.parse(template)
+	if err != nil {
+		return nil, err
+	}
+	re := pattern(parts)
+
+	var result []string
+	for _, name := range names {
+		if re.MatchString(name) {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// unused renders name for index, random parts are regenerated on collision
+func (n *Naming) unused(parts []part, index int, used map[string]bool) (string, error) {
+	for attempt := 0; attempt < randomAttempts; attempt++ {
+		name := n.render(parts, index)
+		if !used[name] {
+			return name, nil
+		}
+		if !has(parts, partRand) {
+			return "", fmt.Errorf("host %s already exists", name)
+		}
+	}
+	return "", fmt.Errorf("can't find unused hostname in %d attempts, please increase random part", randomAttempts)
+}
+
+// parse replaces vars and splits template to literal, seq and rand parts
+func (n *Naming) parse(template string) ([]part, error) {
+	var parts []part
+	var literal strings.Builder
+	seqCount := 0
+
+	last := 0
+	for _, m := range placeholder.FindAllStringSubmatchIndex(template, -1) {
+		literal.WriteString(template[last:m[0]])
+		last = m[1]
+
+		if template[m[0]] == '*' {
+			parts = appendLiteral(parts, &literal)
+			parts = append(parts, part{kind: partRand, width: n.config.RandomLength})
+			continue
+		}
+
+		name := template[m[2]:m[3]]
+		width := 0
+		if m[4] >= 0 {
+			width, _ = strconv.Atoi(template[m[4]:m[5]])
+		}
+		switch name {
+		case "seq":
+			seqCount++
+			parts = appendLiteral(parts, &literal)
+			parts = append(parts, part{kind: partSeq, width: width})
+		case "rand":
+			if width == 0 {
+				return nil, fmt.Errorf("hostname %s: please set length like {rand:5}", template)
+			}
+			parts = appendLiteral(parts, &literal)
+			parts = append(parts, part{kind: partRand, width: width})
+		default:
+			value, ok := n.config.Vars[name]
+			if !ok {
+				return nil, fmt.Errorf("hostname %s: unknown placeholder {%s}", template, name)
+			}
+			if value == "" {
+				return nil, fmt.Errorf("hostname %s: {%s} is empty", template, name)
+			}
+			literal.WriteString(value)
+		}
+	}
+	literal.WriteString(template[last:])
+	parts = appendLiteral(parts, &literal)
+
+	if seqCount > 1 {
+		return nil, fmt.Errorf("hostname %s: only one {seq} is allowed", template)
+	}
+	for _, p := range parts {
+		if p.kind == partRand && p.width <= 0 {
+			return nil, fmt.Errorf("hostname %s: random part length should be greater than 0", template)
+		}
+	}
+	return parts, nil
+}
+
+func (n *Naming) render(parts []part, index int) string {
+	var name strings.Builder
+	for _, p := range parts {
+		switch p.kind {
+		case partLiteral:
+			name.WriteString(p.text)
+		case partSeq:
+			name.WriteString(fmt.Sprintf("%0*d", p.width, index))
+		case partRand:
+			for i := 0; i < p.width; i++ {
+				name.WriteByte(randomChars[n.random.Intn(len(randomChars))])
+			}
+		}
+	}
+	return name.String()
+}
+
+// pattern matches names from template parts, names with domain are matched too
+func pattern(parts []part) *regexp.Regexp {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for _, p := range parts {
+		switch p.kind {
+		case partLiteral:
+			pattern.WriteString(regexp.QuoteMeta(p.text))
+		case partSeq:
+			pattern.WriteString("([0-9]+)")
+		case partRand:
+			pattern.WriteString(fmt.Sprintf("[%s]{%d}", randomChars, p.width))
+		}
+	}
+	pattern.WriteString(`(\..*)?$`)
+	return regexp.MustCompile(pattern.String())
+}
+
+// maxIndex returns the highest {seq} of existing names
+func maxIndex(parts []part, existing []string) int {
+	re := pattern(parts)
+	max := 0
+	for _, name := range existing {
+		m := re.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		index, err := strconv.Atoi(m[1])
+		if err == nil && index > max {
+			max = index
+		}
+	}
+	return max
+}
+
+func appendLiteral(parts []part, literal *strings.Builder) []part {
+	if literal.Len() > 0 {
+		parts = append(parts, part{kind: partLiteral, text: literal.String()})
+		literal.Reset()
+	}
+	return parts
+}
+
+func has(parts []part, kind int) bool {
+	for _, p := range parts {
+		if p.kind == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package naming
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"regexp"
+	"testing"
+)
+
+type testNodeUP struct{}
+
+func (t testNodeUP) Version() string {
+	return "test"
+}
+
+func (t testNodeUP) Log() *logrus.Entry {
+	return logrus.NewEntry(logrus.New())
+}
+
+func testNaming() *Naming {
+	n := New(testNodeUP{}, Config{
+		Vars:         map[string]string{"role": "search", "env": "production", "az": ""},
+		RandomLength: 5,
+	})
+	n.random = rand.New(rand.NewSource(1))
+	return n
+}
+
+func TestGenerateSeq(t *testing.T) {
+	n := testNaming()
+
+	names, err := n.Generate("{role}-{env}-{seq:03}", 2, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"search-production-001", "search-production-002"}, names)
+
+	existing := []string{"search-production-007", "search-production-012.example.com", "search-staging-020", "search-production-x"}
+	names, err = n.Generate("{role}-{env}-{seq:03}", 2, existing)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"search-production-013", "search-production-014"}, names)
+
+	names, err = n.Generate("{role}{seq}", 1, []string{"search9"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"search10"}, names)
+}
+
+func TestGenerateRandom(t *testing.T) {
+	n := testNaming()
+
+	names, err := n.Generate("{role}-*", 3, []string{"search-abcde"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(names))
+	for _, name := range names {
+		assert.Regexp(t, regexp.MustCompile(`^search-[a-z0-9]{5}$`), name)
+	}
+	assert.NotEqual(t, names[0], names[1])
+
+	names, err = n.Generate("{role}-{rand:2}", 1, nil)
+	assert.Equal(t, nil, err)
+	assert.Regexp(t, regexp.MustCompile(`^search-[a-z0-9]{2}$`), names[0])
+
+	// All one char names are taken
+	var existing []string
+	for _, c := range randomChars {
+		existing = append(existing, "search-"+string(c))
+	}
+	_, err = n.Generate("{role}-{rand:1}", 1, existing)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestGenerateErrors(t *testing.T) {
+	n := testNaming()
+
+	_, err := n.Generate("search-1", 2, nil)
+	assert.EqualError(t, err, "hostname search-1 has no {seq} or {rand:N}, please set -count 1")
+
+	_, err = n.Generate("search-1", 1, []string{"search-1"})
+	assert.EqualError(t, err, "host search-1 already exists")
+
+	names, err := n.Generate("search-1", 1, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"search-1"}, names)
+
+	_, err = n.Generate("{role}-{zone}-{seq}", 1, nil)
+	assert.EqualError(t, err, "hostname {role}-{zone}-{seq}: unknown placeholder {zone}")
+
+	_, err = n.Generate("{role}-{az}-{seq}", 1, nil)
+	assert.EqualError(t, err, "hostname {role}-{az}-{seq}: {az} is empty")
+
+	_, err = n.Generate("{seq}-{seq}", 1, nil)
+	assert.EqualError(t, err, "hostname {seq}-{seq}: only one {seq} is allowed")
+
+	_, err = n.Generate("{role}-{rand}", 1, nil)
+	assert.EqualError(t, err, "hostname {role}-{rand}: please set length like {rand:5}")
+}
+
+func TestMatch(t *testing.T) {
+	n := testNaming()
+
+	names := []string{"search-production-01", "search-production-ab12c", "search-production-ab12c.example.com", "search-production-ab12", "search-staging-ab12c", "search-production-AB12C"}
+	matched, err := n.Match("{role}-{env}-*", names)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"search-production-ab12c", "search-production-ab12c.example.com"}, matched)
+
+	matched, err = n.Match("{role}-{env}-{seq:02}", names)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"search-production-01"}, matched)
+
+	_, err = n.Match("{role}-{zone}-*", names)
+	assert.NotEqual(t, nil, err)
+}
@@ -0,0 +1,34 @@
+package naming
+
+import (
+	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+	"math/rand"
+)
+
+// Config of hostname templates like {role}-{env}-{seq:03}
+type Config struct {
+	// Vars replace placeholders like {role} and {az}
+	Vars map[string]string
+	// RandomLength is length of legacy * placeholder
+	RandomLength int
+}
+
+type Naming struct {
+	nodeup nodeup.NodeUP
+	config Config
+	random *rand.Rand
+}
+
+// Template part kinds
+const (
+	partLiteral = iota
+	partSeq
+	partRand
+)
+
+// part of parsed template, width is seq padding or rand length
+type part struct {
+	kind  int
+	text  string
+	width int
+}
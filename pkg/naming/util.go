@@ -0,0 +1,10 @@
+package naming
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+func (n *Naming) Log() *logrus.Entry {
+	log := n.nodeup.Log().WithField("context", "naming")
+	return log
+}
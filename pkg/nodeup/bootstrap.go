@@ -0,0 +1,477 @@
+package nodeup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/onetwotrip/nodeup/pkg/provisioner"
+	"github.com/onetwotrip/nodeup/pkg/ssh"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Bootstrap steps in order
+const (
+	StepCreate     = "create"
+	StepWaitActive = "wait-active"
+	StepWaitSSH    = "wait-ssh"
+	StepNetwork    = "network"
+	StepUpload     = "upload"
+	StepInstall    = "install"
+	StepFirstRun   = "first-run"
+	StepCleanup    = "cleanup"
+	StepVerify     = "verify"
+	// StepCloudInit replaces SSH steps in cloud-init mode
+	StepCloudInit = "cloud-init"
+)
+
+// Delay between step attempts
+const stepRetryDelay = 10 * time.Second
+
+// State is saved after every bootstrap step for -resume
+type State struct {
+	Host        *Host
+	Domain      string
+	Provisioner string
+
+	// Step is the last completed step
+	Step       string
+	FailedStep string
+	Error      string
+	Updated    time.Time
+}
+
+// step is retried before host teardown
+type step struct {
+	name    string
+	retries int
+	run     func(b *bootstrap) error
+}
+
+var steps = []step{
+	{name: StepCreate, run: (*bootstrap).create},
+	{name: StepWaitActive, run: (*bootstrap).waitActive},
+	{name: StepWaitSSH, run: (*bootstrap).waitSSH},
+	{name: StepNetwork, retries: 2, run: (*bootstrap).network},
+	{name: StepUpload, retries: 2, run: (*bootstrap).upload},
+	{name: StepInstall, retries: 1, run: (*bootstrap).install},
+	{name: StepFirstRun, retries: 1, run: (*bootstrap).firstRun},
+	{name: StepCleanup, retries: 1, run: (*bootstrap).cleanup},
+	{name: StepVerify, run: (*bootstrap).verify},
+}
+
+// bootstrap keeps connections between steps of one host
+type bootstrap struct {
+	nodeup *NodeUP
+	state  *State
+	out    *os.File
+
+	ssh         *ssh.Ssh
+	provisioner provisioner.Provisioner
+}
+
+// BootstrapHost creates server and configures it with provisioner
+func (o *NodeUP) BootstrapHost(h *Host) bool {
+	if o.BootstrapMode == "cloudinit" {
+		return o.bootstrapCloudInit(h)
+	}
+
+	outFile, err := os.Create(h.LogFile)
+	if err != nil {
+		o.Log().Debugf("Couldn't create a logFile for %s: %s", h.Hostname, err)
+	}
+
+	b := &bootstrap{
+		nodeup: o,
+		state: &State{
+			Host:        h,
+			Domain:      o.Domain,
+			Provisioner: o.Provisioner,
+		},
+		out: outFile,
+	}
+	return b.run(StepCreate, false)
+}
+
+// ResumeHost continues bootstrap on existing server from the failed step, result is in Host
+func (o *NodeUP) ResumeHost(hostname string) (*Host, error) {
+	state, err := o.loadState(hostname)
+	if err != nil {
+		return nil, err
+	}
+	if state.Provisioner != o.Provisioner {
+		return nil, fmt.Errorf("host was bootstrapped with provisioner %s, please provide -provisioner %s", state.Provisioner, state.Provisioner)
+	}
+	if o.Domain == "" {
+		o.Domain = state.Domain
+	}
+
+	next := state.FailedStep
+	if next == "" {
+		next = nextStep(state.Step)
+	}
+	if next == "" {
+		o.Log().Infof("Host %s is already bootstrapped", hostname)
+		o.removeState(hostname)
+		state.Host.Result = &Result{Success: true}
+		return state.Host, nil
+	}
+	if next != StepCreate {
+		_, err = o.Openstack.GetServer(state.Host.ServerID)
+		if err != nil {
+			return nil, fmt.Errorf("server %s: %s", state.Host.ServerID, err)
+		}
+	}
+
+	outFile, err := os.OpenFile(state.Host.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		o.Log().Debugf("Couldn't open a logFile for %s: %s", hostname, err)
+	}
+
+	o.Log().Infof("Resuming host %s from step %s", hostname, next)
+	b := &bootstrap{
+		nodeup: o,
+		state:  state,
+		out:    outFile,
+	}
+	b.run(next, true)
+	return state.Host, nil
+}
+
+// run executes steps starting from first, server is kept on resume failure
+func (b *bootstrap) run(first string, resume bool) bool {
+	o := b.nodeup
+	hostname := b.state.Host.Hostname
+	start := time.Now()
+	defer b.close()
+
+	if o.JenkinsMode {
+		o.Log().Infof("Processing log %s%s.log", o.JenkinsLogURL, hostname)
+	}
+
+	var results []StepResult
+	started := false
+	for _, s := range steps {
+		if s.name == first {
+			started = true
+		}
+		if !started {
+			continue
+		}
+
+		stepStart := time.Now()
+		err := b.runStep(s)
+		results = append(results, stepResult(s.name, stepStart, err))
+		if err != nil {
+			b.state.FailedStep = s.name
+			b.state.Error = err.Error()
+			b.save()
+			b.fail(s.name, err, resume)
+			b.state.Host.Result = &Result{
+				Step:        s.name,
+				Interrupted: o.Stopped(),
+				Error:       err.Error(),
+				Duration:    time.Since(start),
+				Steps:       results,
+			}
+			return false
+		}
+		b.state.Step = s.name
+		b.state.FailedStep = ""
+		b.state.Error = ""
+		b.save()
+	}
+
+	o.Journal.Commit(hostname)
+	o.removeState(hostname)
+	b.state.Host.Result = &Result{
+		Success:  true,
+		Duration: time.Since(start),
+		Steps:    results,
+	}
+	o.Log().Infof("Host %s bootstrapped", hostname)
+	return true
+}
+
+func (b *bootstrap) runStep(s step) error {
+	o := b.nodeup
+	hostname := b.state.Host.Hostname
+
+	var err error
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		if attempt > 0 {
+			o.Log().Warnf("Step %s on host %s failed: %s. Retry %d of %d", s.name, hostname, err, attempt, s.retries)
+			select {
+			case <-o.StopCh:
+			case <-time.After(stepRetryDelay):
+			}
+		}
+		// New steps are not started after Stop
+		if o.Stopped() {
+			return errors.New("interrupted")
+		}
+
+		o.Log().Infof("Step %s on host %s", s.name, hostname)
+		err = s.run(b)
+		if err == nil {
+			return nil
+		}
+		// Broken connection is the usual reason, so next attempt reconnects
+		b.closeSSH()
+	}
+	return err
+}
+
+// fail rolls back created resources unless host is kept for -resume
+// Server is kept after it became active, -deleteNodes removes it with state
+func (b *bootstrap) fail(name string, err error, resume bool) {
+	o := b.nodeup
+	hostname := b.state.Host.Hostname
+	o.Log().Errorf("Bootstrap error on host %s at step %s: %s", hostname, name, err)
+
+	if b.state.Host.ServerID != "" && (o.IgnoreFail || resume || resumable(name)) {
+		o.Journal.Keep(hostname)
+		o.Log().Warnf("Host %s is kept, continue bootstrap with: nodeup -resume %s or remove it with: nodeup -deleteNodes %s", hostname, hostname, hostname)
+		return
+	}
+	if !o.Journal.Rollback(hostname) {
+		o.Log().Errorf("Can't cleanup node %s", hostname)
+	}
+	o.removeState(hostname)
+}
+
+// resumable is step failed on active server, fresh server is not better for it
+func resumable(name string) bool {
+	return name != StepCreate && name != StepWaitActive
+}
+
+func (b *bootstrap) create() error {
+	o := b.nodeup
+	err := o.createServer(b.state.Host, nil)
+	if err != nil {
+		return err
+	}
+	o.recordChef(b.state.Host)
+	return nil
+}
+
+func (b *bootstrap) waitActive() error {
+	o := b.nodeup
+	h := b.state.Host
+	server, err := o.Openstack.WaitServerActive(o.ctx, h.ServerID, o.OSRetryTimeout)
+	if err != nil {
+		return err
+	}
+
+	addresses, private := o.GetAddress(server.Addresses)
+	if len(addresses) == 0 {
+		return fmt.Errorf("server %s has no addresses", h.ServerID)
+	}
+	o.Log().Debugf("Ip Address for host %s: %s", h.Hostname, addresses[0])
+	h.Addresses = addresses
+	h.PrivateNetwork = private
+	return nil
+}
+
+func (b *bootstrap) waitSSH() error {
+	o := b.nodeup
+	if !o.checkSSHPort(b.state.Host.Address()) {
+		return fmt.Errorf("SSH is unreachable on host %s", b.state.Host.Hostname)
+	}
+	return o.pinHostKeys(b.state.Host.ServerID, b.state.Host.Address())
+}
+
+func (b *bootstrap) network() error {
+	o := b.nodeup
+	if !b.state.Host.PrivateNetwork {
+		return nil
+	}
+
+	s, err := b.connect()
+	if err != nil {
+		return err
+	}
+	err = s.TransferFile(o.createInterfacesFile(o.Gateway), "00-sc-network.yaml", o.SSHUploadDir)
+	if err != nil {
+		return err
+	}
+	for _, command := range o.configureDefaultGateway(o.SSHUploadDir) {
+		command.Output = b.out
+		_, err = s.Run(o.ctx, command)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upload renders provisioner files, chef client key is created again on every attempt
+func (b *bootstrap) upload() error {
+	s, err := b.connect()
+	if err != nil {
+		return err
+	}
+	p, err := b.provision()
+	if err != nil {
+		return err
+	}
+	err = p.Prepare()
+	if err != nil {
+		return err
+	}
+	return p.Upload(s)
+}
+
+func (b *bootstrap) install() error {
+	return b.provisionStep(provisioner.Provisioner.Install)
+}
+
+func (b *bootstrap) firstRun() error {
+	return b.provisionStep(provisioner.Provisioner.Run)
+}
+
+func (b *bootstrap) cleanup() error {
+	return b.provisionStep(provisioner.Provisioner.Cleanup)
+}
+
+func (b *bootstrap) provisionStep(run func(provisioner.Provisioner, context.Context, *ssh.Ssh, *os.File) error) error {
+	s, err := b.connect()
+	if err != nil {
+		return err
+	}
+	p, err := b.provision()
+	if err != nil {
+		return err
+	}
+	return run(p, b.nodeup.ctx, s, b.out)
+}
+
+// verify checks host serves traffic and chef node has converged
+func (b *bootstrap) verify() error {
+	o := b.nodeup
+
+	var s *ssh.Ssh
+	if o.VerifyCommand != "" {
+		var err error
+		s, err = b.connect()
+		if err != nil {
+			return err
+		}
+	}
+	err := o.newVerify().Host(o.ctx, b.state.Host.Address(), s)
+	if err != nil {
+		return err
+	}
+	return o.verifyChef(b.state.Host)
+}
+
+// connect opens SSH connection once for all steps
+func (b *bootstrap) connect() (*ssh.Ssh, error) {
+	if b.ssh != nil {
+		return b.ssh, nil
+	}
+	o := b.nodeup
+	s, err := ssh.New(o, b.state.Host.Address(), o.SSHUser, o.SSHConfig())
+	if err != nil {
+		return nil, err
+	}
+	b.ssh = s
+	return s, nil
+}
+
+// provision creates provisioner, files are rendered by upload step only
+func (b *bootstrap) provision() (provisioner.Provisioner, error) {
+	if b.provisioner != nil {
+		return b.provisioner, nil
+	}
+	p, err := b.nodeup.newProvisioner(b.state.Host, b.nodeup.SSHUploadDir)
+	if err != nil {
+		return nil, err
+	}
+	b.provisioner = p
+	return p, nil
+}
+
+func (b *bootstrap) closeSSH() {
+	if b.ssh != nil {
+		b.ssh.Close()
+		b.ssh = nil
+	}
+}
+
+func (b *bootstrap) close() {
+	b.closeSSH()
+	if b.out != nil {
+		b.out.Close()
+	}
+}
+
+func (b *bootstrap) save() {
+	b.state.Updated = time.Now()
+	err := b.nodeup.saveState(b.state)
+	if err != nil {
+		b.nodeup.Log().Errorf("Can't save state of host %s: %s", b.state.Host.Hostname, err)
+	}
+}
+
+// Address is used for SSH and checks
+func (h *Host) Address() string {
+	if len(h.Addresses) == 0 {
+		return ""
+	}
+	return h.Addresses[0]
+}
+
+func stepResult(name string, start time.Time, err error) StepResult {
+	r := StepResult{Name: name, Duration: time.Since(start)}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+func nextStep(name string) string {
+	for i, s := range steps {
+		if s.name == name && i+1 < len(steps) {
+			return steps[i+1].name
+		}
+	}
+	return ""
+}
+
+func (o *NodeUP) statePath(hostname string) string {
+	return o.LogDir + "/" + hostname + ".state.json"
+}
+
+func (o *NodeUP) saveState(state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(o.statePath(state.Host.Hostname), data, 0644)
+}
+
+func (o *NodeUP) loadState(hostname string) (*State, error) {
+	data, err := ioutil.ReadFile(o.statePath(hostname))
+	if err != nil {
+		return nil, err
+	}
+	state := &State{}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, err
+	}
+	if state.Host == nil || state.Host.Hostname != hostname {
+		return nil, errors.New("state file is not valid")
+	}
+	return state, nil
+}
+
+func (o *NodeUP) removeState(hostname string) {
+	err := os.Remove(o.statePath(hostname))
+	if err != nil && !os.IsNotExist(err) {
+		o.Log().Debugf("Can't remove state of host %s: %s", hostname, err)
+	}
+}
@@ -0,0 +1,81 @@
+package nodeup
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNextStep(t *testing.T) {
+	assert.Equal(t, StepWaitActive, nextStep(StepCreate))
+	assert.Equal(t, StepInstall, nextStep(StepUpload))
+	assert.Equal(t, "", nextStep(StepVerify))
+}
+
+func TestState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeup")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	o := New("test", logrus.NewEntry(logrus.New()))
+	o.LogDir = dir
+
+	state := &State{
+		Host: &Host{
+			Hostname:    "search-ab12c",
+			ChefRunList: []string{"role[search]"},
+			ServerID:    "id-1",
+			Addresses:   []string{"10.0.0.1"},
+		},
+		Domain:      "example.com",
+		Provisioner: "chef",
+		Step:        StepUpload,
+		FailedStep:  StepInstall,
+		Error:       "exit code 100",
+	}
+	assert.Equal(t, nil, o.saveState(state))
+
+	loaded, err := o.loadState("search-ab12c")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "id-1", loaded.Host.ServerID)
+	assert.Equal(t, []string{"role[search]"}, loaded.Host.ChefRunList)
+	assert.Equal(t, StepInstall, loaded.FailedStep)
+	assert.Equal(t, "10.0.0.1", loaded.Host.Address())
+
+	_, err = o.loadState("search-cd34e")
+	assert.NotEqual(t, nil, err)
+
+	o.removeState("search-ab12c")
+	_, err = o.loadState("search-ab12c")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStop(t *testing.T) {
+	o := New("test", logrus.NewEntry(logrus.New()))
+	assert.False(t, o.Stopped())
+
+	o.Stop()
+	o.Stop()
+	assert.True(t, o.Stopped())
+
+	b := &bootstrap{nodeup: o, state: &State{Host: &Host{Hostname: "search-ab12c"}}}
+	run := false
+	err := b.runStep(step{name: StepCreate, run: func(b *bootstrap) error {
+		run = true
+		return nil
+	}})
+	assert.EqualError(t, err, "interrupted")
+	assert.False(t, run)
+
+	// Zero grace period cancels in-flight steps at once
+	select {
+	case <-o.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("context is not cancelled after grace period")
+	}
+	assert.Equal(t, context.Canceled, o.sleep(time.Minute))
+}
@@ -0,0 +1,166 @@
+package nodeup
+
+import (
+	"errors"
+	"fmt"
+	"github.com/onetwotrip/nodeup/pkg/cloudinit"
+	"github.com/onetwotrip/nodeup/pkg/provisioner"
+	"io/ioutil"
+	"time"
+)
+
+// Bootstrap files directory on host in cloud-init mode
+const cloudInitDir = "/var/lib/nodeup"
+
+// bootstrapCloudInit passes bootstrap as user-data and waits for it without SSH
+func (o *NodeUP) bootstrapCloudInit(h *Host) bool {
+	cloudInitSteps := []struct {
+		name string
+		run  func(h *Host) error
+	}{
+		{StepCreate, o.createCloudInit},
+		{StepWaitActive, o.waitActiveCloudInit},
+		{StepCloudInit, o.waitCloudInit},
+		{StepVerify, o.verifyCloudInit},
+	}
+
+	start := time.Now()
+	result := &Result{Success: true}
+	for _, s := range cloudInitSteps {
+		if o.Stopped() {
+			result.Success = false
+			result.Step = s.name
+			result.Error = "interrupted"
+			break
+		}
+		stepStart := time.Now()
+		err := s.run(h)
+		result.Steps = append(result.Steps, stepResult(s.name, stepStart, err))
+		if err != nil {
+			result.Success = false
+			result.Step = s.name
+			result.Error = err.Error()
+			break
+		}
+	}
+	result.Duration = time.Since(start)
+	result.Interrupted = !result.Success && o.Stopped()
+	h.Result = result
+
+	if !result.Success {
+		o.failHost(h.Hostname, errors.New(result.Error))
+		return false
+	}
+	o.Journal.Commit(h.Hostname)
+	o.Log().Infof("Host %s bootstrapped by cloud-init", h.Hostname)
+	return true
+}
+
+func (o *NodeUP) createCloudInit(h *Host) error {
+	// Validatorless bootstrap creates client and node in Prepare
+	o.recordChef(h)
+	p, err := o.newProvisioner(h, cloudInitDir)
+	if err == nil {
+		err = p.Prepare()
+	}
+	if err != nil {
+		return err
+	}
+
+	userData, err := o.createUserData(h, p)
+	if err != nil {
+		return fmt.Errorf("can't render user-data: %s", err)
+	}
+	return o.createServer(h, userData)
+}
+
+func (o *NodeUP) waitActiveCloudInit(h *Host) error {
+	server, err := o.Openstack.WaitServerActive(o.ctx, h.ServerID, o.OSRetryTimeout)
+	if err != nil {
+		return err
+	}
+	h.Addresses, h.PrivateNetwork = o.GetAddress(server.Addresses)
+	return nil
+}
+
+func (o *NodeUP) createUserData(h *Host, p provisioner.Provisioner) ([]byte, error) {
+	files := make(map[string][]byte)
+	for name, data := range p.Files() {
+		files[name] = data
+	}
+
+	var commands []string
+	// Server address is unknown before create, so gateway is configured when given
+	if o.Gateway != "" {
+		files["/etc/netplan/00-sc-network.yaml"] = o.createInterfacesFile(o.Gateway)
+		commands = append(commands, "sudo netplan generate", "sudo netplan apply")
+	}
+
+	return cloudinit.Render(&cloudinit.Config{
+		Hostname: h.Hostname,
+		Domain:   o.Domain,
+		Dir:      cloudInitDir,
+		Files:    files,
+		Commands: append(commands, p.Commands()...),
+	})
+}
+
+// waitCloudInit looks for bootstrap markers in console log.
+// Chef node converge time is checked when console log is not available
+func (o *NodeUP) waitCloudInit(h *Host) error {
+	o.Log().Infof("Waiting cloud-init bootstrap on host %s", h.Hostname)
+	defer o.saveConsoleLog(h)
+
+	deadline := time.Now().Add(time.Duration(o.CloudInitTimeout) * time.Minute)
+	for time.Now().Before(deadline) {
+		if err := o.sleep(15 * time.Second); err != nil {
+			return err
+		}
+
+		console, err := o.Openstack.ConsoleOutput(h.ServerID, 200)
+		if err == nil {
+			done, err := cloudinit.Status(console)
+			if done {
+				return err
+			}
+			continue
+		}
+		o.Log().Debugf("Console log for host %s is not available: %s", h.Hostname, err)
+
+		if o.Chef == nil || o.Provisioner != "chef" {
+			continue
+		}
+		ohaiTime, err := o.Chef.OhaiTime(h.Hostname)
+		if err == nil && ohaiTime > 0 {
+			return nil
+		}
+	}
+	return errors.New("timeout waiting cloud-init bootstrap")
+}
+
+// verifyCloudInit runs TCP, HTTP and chef checks, command check needs SSH
+func (o *NodeUP) verifyCloudInit(h *Host) error {
+	if h.Address() == "" {
+		return errors.New("server has no addresses")
+	}
+	err := o.newVerify().Host(o.ctx, h.Address(), nil)
+	if err != nil {
+		return err
+	}
+	return o.verifyChef(h)
+}
+
+func (o *NodeUP) saveConsoleLog(h *Host) {
+	console, err := o.Openstack.ConsoleOutput(h.ServerID, 0)
+	if err != nil {
+		o.Log().Debugf("Can't get console log for host %s: %s", h.Hostname, err)
+		return
+	}
+	if o.JenkinsMode {
+		o.Log().Infof("Processing log %s%s.log", o.JenkinsLogURL, h.Hostname)
+	}
+	err = ioutil.WriteFile(h.LogFile, []byte(console), 0644)
+	if err != nil {
+		o.Log().Debugf("Couldn't write a logFile for %s: %s", h.Hostname, err)
+	}
+}
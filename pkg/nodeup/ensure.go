@@ -0,0 +1,162 @@
+package nodeup
+
+import (
+	"fmt"
+	"github.com/onetwotrip/nodeup/pkg/chef"
+	"github.com/onetwotrip/nodeup/pkg/plan"
+	"sort"
+	"time"
+)
+
+// member is existing server matching -name in -ensure mode
+type member struct {
+	name    string
+	created time.Time
+	// problem is empty for healthy host
+	problem string
+}
+
+// ensure creates missing hosts or deletes surplus ones so that -count servers match -name
+func (o *NodeUP) ensure() int {
+	started := time.Now()
+	members, orphans, err := o.members()
+	if err != nil {
+		o.Log().Errorf("Can't find hosts matching %s: %s", o.Name, err)
+		return o.saveReport(started, nil, fmt.Sprintf("can't find hosts matching %s: %s", o.Name, err), 1)
+	}
+	o.Log().Infof("Found %d host(s) matching %s, expected %d", len(members), o.Name, o.Count)
+	for _, m := range members {
+		if m.problem != "" {
+			o.Log().Warnf("Host %s is unhealthy: %s", m.name, m.problem)
+		}
+	}
+
+	var create []string
+	var remove []member
+	switch {
+	case len(members) < o.Count:
+		errs := o.Preflight(o.NewHost(o.Name))
+		create, err = o.Hostnames(o.Name, o.Count-len(members), o.NewHost(o.Name))
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			return o.preflightReport(started, errs)
+		}
+	case len(members) > o.Count:
+		remove = surplus(members, len(members)-o.Count)
+	}
+
+	if o.DryRun {
+		o.PrintPlan(o.ensurePlan(create, remove, orphans))
+		return 0
+	}
+
+	if len(remove) > 0 {
+		var hostnames []string
+		for _, m := range remove {
+			o.Log().Infof("Removing host %s: %s", m.name, m.reason())
+			hostnames = append(hostnames, m.name)
+		}
+		return o.deleteHosts(hostnames)
+	}
+	if len(create) > 0 {
+		return o.createHosts(create)
+	}
+	o.Log().Infof("Nothing to do, %d host(s) match %s", len(members), o.Name)
+	return o.saveReport(started, nil, "", 0)
+}
+
+// members returns servers matching -name, chef node checks are used for health
+// orphans are chef nodes of the same role and environment or policy without server
+func (o *NodeUP) members() ([]member, []string, error) {
+	h := o.NewHost(o.Name)
+	servers, err := o.Openstack.GetServers()
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't list servers: %s", err)
+	}
+	var names []string
+	for _, s := range servers {
+		names = append(names, s.Name)
+	}
+	matched, err := o.naming(h).Match(o.Name, names)
+	if err != nil {
+		return nil, nil, err
+	}
+	found := map[string]bool{}
+	for _, name := range matched {
+		found[name] = true
+	}
+
+	var members []member
+	for _, s := range servers {
+		if !found[s.Name] {
+			continue
+		}
+		m := member{name: s.Name, created: s.Created}
+		if s.Status != "ACTIVE" {
+			m.problem = "server status is " + s.Status
+		} else if err := o.verifyChef(o.NewHost(s.Name)); err != nil {
+			m.problem = err.Error()
+		}
+		members = append(members, m)
+	}
+
+	var orphans []string
+	if o.Chef != nil {
+		roles, _ := chef.ParseRunList(h.ChefRunList)
+		nodes, err := o.Chef.SearchNodes(h.ChefEnvironment, roles, h.ChefPolicyName, h.ChefPolicyGroup)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't search chef nodes: %s", err)
+		}
+		exists := map[string]bool{}
+		for _, name := range names {
+			exists[name] = true
+		}
+		for _, name := range nodes {
+			if !exists[name] {
+				orphans = append(orphans, name)
+			}
+		}
+		sort.Strings(orphans)
+	}
+	return members, orphans, nil
+}
+
+// surplus returns count members to delete, unhealthy and newest first
+func surplus(members []member, count int) []member {
+	sorted := append([]member(nil), members...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if (sorted[i].problem != "") != (sorted[j].problem != "") {
+			return sorted[i].problem != ""
+		}
+		return sorted[i].created.After(sorted[j].created)
+	})
+	return sorted[:count]
+}
+
+func (m member) reason() string {
+	if m.problem != "" {
+		return "unhealthy, " + m.problem
+	}
+	return "newest, created " + m.created.Format(time.RFC3339)
+}
+
+func (o *NodeUP) ensurePlan(create []string, remove []member, orphans []string) *plan.Plan {
+	p := plan.New("ensure")
+	p.Orphans = orphans
+	if len(create) > 0 {
+		p.Create = o.createPlan(create).Create
+	}
+	if len(remove) > 0 {
+		var hostnames []string
+		for _, m := range remove {
+			hostnames = append(hostnames, m.name)
+		}
+		p.Delete = o.deletePlan(hostnames).Delete
+		for i := range p.Delete {
+			p.Delete[i].Reason = remove[i].reason()
+		}
+	}
+	return p
+}
@@ -0,0 +1,126 @@
+package nodeup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/onetwotrip/nodeup/pkg/chef"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMembers(t *testing.T) {
+	o := New("test", logrus.NewEntry(logrus.New()))
+	o.Openstack = &fakeOpenstack{servers: map[string]string{
+		"id-1": "search-01",
+		"id-2": "search-02",
+		"id-3": "search-fail",
+		"id-4": "backend-01",
+	}}
+	o.Name = "search-{seq:02}"
+
+	members, orphans, err := o.members()
+	assert.Equal(t, nil, err)
+	var names []string
+	for _, m := range members {
+		names = append(names, m.name)
+		assert.Equal(t, "", m.problem)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"search-01", "search-02"}, names)
+
+	o.Name = "search-*"
+	o.PrefixCharts = 4
+	members, orphans, err = o.members()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(members))
+	assert.Equal(t, "server status is ERROR", members[0].problem)
+	assert.Equal(t, []string(nil), orphans)
+}
+
+func TestMembersOrphans(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Equal(t, nil, err)
+
+	// Chef server knows search-01 and two nodes without server, only one of them matches -name
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/search/node":
+			query = r.URL.Query().Get("q")
+			w.Write([]byte(`{"total": 3, "start": 0, "rows": [
+				{"url": "/nodes/search-01", "data": {"name": "search-01"}},
+				{"url": "/nodes/search-03", "data": {"name": "search-03"}},
+				{"url": "/nodes/search-old", "data": {"name": "search-old"}}
+			]}`))
+		case r.Method == "GET" && r.URL.Path == "/nodes/search-01":
+			w.Write([]byte(`{"name": "search-01", "chef_environment": "production", "run_list": ["role[search]"], "automatic": {"ohai_time": 1}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": ["not found"]}`))
+		}
+	}))
+	defer server.Close()
+
+	o := New("test", logrus.NewEntry(logrus.New()))
+	o.Openstack = &fakeOpenstack{servers: map[string]string{
+		"id-1": "search-01",
+		"id-2": "search-02",
+		"id-3": "backend-01",
+	}}
+	o.Name = "search-{seq:02}"
+	o.Provisioner = "chef"
+	o.ChefRole = "search"
+	o.ChefEnvironment = "production"
+	o.Chef, err = chef.NewChefClient(o, "admin", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), server.URL+"/")
+	assert.Equal(t, nil, err)
+
+	members, orphans, err := o.members()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "role:search AND chef_environment:production", query)
+	assert.Equal(t, []string{"search-03", "search-old"}, orphans)
+	problems := map[string]string{}
+	for _, m := range members {
+		problems[m.name] = m.problem
+	}
+	assert.Equal(t, 2, len(problems))
+	assert.Equal(t, "", problems["search-01"])
+	assert.Contains(t, problems["search-02"], "chef node search-02")
+
+	var buf bytes.Buffer
+	assert.Equal(t, nil, o.ensurePlan(nil, nil, orphans).Print(&buf, "text"))
+	assert.Contains(t, buf.String(), "Chef nodes without server 2, not removed:")
+	assert.Contains(t, buf.String(), "  ! search-old  remove with -deleteNodes search-old")
+
+	o.ChefRole = ""
+	o.ChefPolicyName = "search"
+	o.ChefPolicyGroup = "staging"
+	_, _, err = o.members()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "policy_name:search AND policy_group:staging", query)
+}
+
+func TestSurplus(t *testing.T) {
+	now := time.Now()
+	members := []member{
+		{name: "search-01", created: now.Add(-3 * time.Hour)},
+		{name: "search-02", created: now.Add(-2 * time.Hour), problem: "chef node is not found"},
+		{name: "search-03", created: now.Add(-time.Hour)},
+		{name: "search-04", created: now},
+	}
+
+	var names []string
+	for _, m := range surplus(members, 3) {
+		names = append(names, m.name)
+	}
+	assert.Equal(t, []string{"search-02", "search-04", "search-03"}, names)
+	assert.Equal(t, "search-01", members[0].name)
+	assert.Equal(t, "unhealthy, chef node is not found", members[1].reason())
+}
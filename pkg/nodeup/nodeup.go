@@ -1,11 +1,15 @@
 package nodeup
 
 import (
-	garbler "github.com/michaelbironneau/garbler/lib"
+	"context"
 	"github.com/onetwotrip/nodeup/pkg/chef"
+	"github.com/onetwotrip/nodeup/pkg/naming"
 	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
-	"github.com/onetwotrip/nodeup/pkg/openstack"
+	"github.com/onetwotrip/nodeup/pkg/pool"
+	"github.com/onetwotrip/nodeup/pkg/provisioner"
+	"github.com/onetwotrip/nodeup/pkg/rollback"
 	"github.com/onetwotrip/nodeup/pkg/ssh"
+	"github.com/onetwotrip/nodeup/pkg/verify"
 	"os"
 	"os/signal"
 	"strings"
@@ -24,12 +28,17 @@ import (
 var _ nodeup.NodeUP = &NodeUP{}
 
 func New(version string, logging *log.Entry) *NodeUP {
-	return &NodeUP{
+	ctx, cancel := context.WithCancel(context.Background())
+	o := &NodeUP{
 		Ver:       version,
 		Logging:   logging,
 		StopCh:    make(chan struct{}),
 		WaitGroup: sync.WaitGroup{},
+		ctx:       ctx,
+		cancel:    cancel,
 	}
+	o.Journal = rollback.New(o, o.removers())
+	return o
 }
 
 func (o *NodeUP) Init() {
@@ -37,16 +46,6 @@ func (o *NodeUP) Init() {
 
 	o.Exitcode = 0
 
-	// handle sigterm correctly
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		s := <-c
-		logger := o.Log().WithField("signal", s.String())
-		logger.Debug("received signal")
-		o.Stop()
-	}()
-
 	if _, err := os.Stat(o.LogDir); os.IsNotExist(err) {
 		o.Log().Debugf("Creating logs directory in %s", o.LogDir)
 		err = os.Mkdir(o.LogDir, 0775)
@@ -55,138 +54,228 @@ func (o *NodeUP) Init() {
 		}
 	}
 
-	if o.Count > 1 && !o.isWildcard(o.Name) {
-		o.Log().Panicf("Can't create more one host with not unique name. Please set -count 1")
+	if o.DeleteNodes != "" && o.DryRun {
+		o.PrintPlan(o.deletePlan(strings.Split(o.DeleteNodes, ",")))
+		os.Exit(0)
 	}
 
 	if o.DeleteNodes != "" {
-		exit := 0
-		for _, hostname := range strings.Split(o.DeleteNodes, ",") {
-			serverID, err := o.Openstack.IDFromName(hostname)
-			if err != nil {
-				o.Log().Errorf("Can't retrive serverID: %s", err)
-			}
-			err = o.Openstack.DeleteServer(serverID)
-			if err != nil {
-				o.Log().Errorf("Server %s delete problem openstack", hostname)
-				exit = 1
-			} else {
-				o.Log().Infof("Server %s successfully deleted from openstack", hostname)
-			}
-			_, err = o.Chef.CleanupNode(hostname, hostname)
-			if err != nil {
-				o.Log().Errorf("Server %s delete problem chef", hostname)
-				o.Log().Error(err)
-				exit = 1
-			} else {
-				o.Log().Infof("Server %s successfully deleted from chef", hostname)
-			}
-		}
-		os.Exit(exit)
+		os.Exit(o.deleteHosts(strings.Split(o.DeleteNodes, ",")))
 	}
 
-	var wg sync.WaitGroup
-	for _, hostname := range o.nameGenerator(o.Name, o.Count) {
-		o.Log().Debugf("Starting goroutine for host %s", hostname)
-		wg.Add(1)
-		go func(hostname string) {
-			if !o.bootstrapHost(o.Openstack, o.Chef, hostname, &wg) {
-				o.Exitcode = 1
-			}
-		}(hostname)
+	if o.Resume != "" {
+		os.Exit(o.resume(o.Resume))
 	}
-	o.Log().Debug("Waiting for workers to finish")
-	wg.Wait()
-	os.Exit(o.Exitcode)
-}
 
-func (o *NodeUP) bootstrapHost(s *openstack.Openstack, c *chef.ChefClient, hostname string, wg *sync.WaitGroup) bool {
-	defer wg.Done()
-
-	oHost, err := s.CreateServer(hostname, o.OSRetryTimeout, o.OSGroupID, o.DefineNetworks, o.AvailabilityZone)
-	if err != nil {
-		return false
+	if o.Ensure {
+		os.Exit(o.ensure())
 	}
 
-	logFile := o.LogDir + "/" + hostname + ".log"
-	outFile, err := os.Create(logFile)
+	// Names are checked against existing servers and chef nodes with other preflight checks
+	started := time.Now()
+	errs := o.Preflight(o.NewHost(o.Name))
+	hostnames, err := o.Hostnames(o.Name, o.Count, o.NewHost(o.Name))
 	if err != nil {
-		log.Debugf("Couldn't create a logFile for %s: %s", hostname, err)
+		errs = append(errs, err)
 	}
-	if o.JenkinsMode {
-		o.Log().Infof("Processing log %s%s.log", o.JenkinsLogURL, hostname)
+	if len(errs) > 0 {
+		os.Exit(o.preflightReport(started, errs))
 	}
 
-	var availableAddresses []string
+	if o.DryRun {
+		o.PrintPlan(o.createPlan(hostnames))
+		os.Exit(0)
+	}
+	os.Exit(o.createHosts(hostnames))
+}
 
-	ipAddresses := o.GetAddress(oHost.Addresses)
-	o.Log().Debugf("Ip Addresses for host %s: %s", hostname, strings.Join(ipAddresses, ","))
-	for _, ip := range ipAddresses {
+// createHosts bootstraps hosts, removes not used shared resources and saves -report
+func (o *NodeUP) createHosts(hostnames []string) int {
+	started := time.Now()
+	var hosts []*Host
+	for _, hostname := range hostnames {
+		hosts = append(hosts, o.NewHost(hostname))
+	}
+	o.Exitcode = o.bootstrapHosts(hosts)
+	if o.Stopped() {
+		o.Log().Error("Bootstrap was interrupted")
+		o.Exitcode = 1
+	}
+	o.Journal.Finish()
+	if len(o.Journal.Report()) > 0 {
+		o.Exitcode = 1
+	}
+	o.Exitcode = o.saveReport(started, o.hostReports(hosts), "", o.Exitcode)
+	return o.Exitcode
+}
 
-		if o.checkSSHPort(ip) {
-			o.Log().Debugf("SSH is accessible on host %s", hostname)
-			availableAddresses = append(availableAddresses, ip)
-		} else {
-			o.Log().Errorf("SSH is unreachable on host %s", hostname)
-			s.DeleteServer(oHost.ID)
-			return false
-		}
+// resume continues bootstrap of one host and saves -report
+func (o *NodeUP) resume(hostname string) int {
+	started := time.Now()
+	h, err := o.ResumeHost(hostname)
+	if err != nil {
+		o.Log().Errorf("Can't resume host %s: %s", hostname, err)
+		return o.saveReport(started, nil, fmt.Sprintf("can't resume host %s: %s", hostname, err), 1)
+	}
+	exitcode := 0
+	if !h.Result.Success {
+		exitcode = 1
+	}
+	return o.saveReport(started, o.hostReports([]*Host{h}), "", exitcode)
+}
 
-		if len(availableAddresses) == 0 {
-			o.Log().Errorf("Can't bootstrap host %s no SSH access", hostname)
-			s.DeleteServer(oHost.ID)
-			return false
+// bootstrapHosts runs workers with own Host each and returns exit code from results
+func (o *NodeUP) bootstrapHosts(hosts []*Host) int {
+	p := o.NewPool()
+	for _, host := range hosts {
+		host := host
+		o.Log().Debugf("Starting worker for host %s", host.Hostname)
+		if !p.Go(func() { o.BootstrapHost(host) }) {
+			break
 		}
+	}
+	p.Wait()
 
-		//Create SSH connection
-		sshClient, err := ssh.New(o, ip, "cloud-user")
-		if o.assertBootstrap(s, c, oHost.ID, hostname, err) {
-			return false
+	exitcode := 0
+	for _, host := range hosts {
+		if host.Result == nil || !host.Result.Success {
+			exitcode = 1
 		}
+	}
+	return exitcode
+}
 
-		//Create Bootstrap data
-		chefData, err := chef.New(o, hostname, o.Domain, o.ChefServerUrl, o.ChefValidationPem, o.ChefValidationPath, []string{"role[" + o.ChefRole + "]"})
-		if o.assertBootstrap(s, c, oHost.ID, hostname, err) {
-			return false
-		}
+// NewPool runs workers with -concurrency and -stagger until Stop
+func (o *NodeUP) NewPool() *pool.Pool {
+	return pool.New(o, pool.Config{
+		Concurrency: o.Concurrency,
+		Stagger:     time.Duration(o.Stagger) * time.Second,
+	}, o.StopCh)
+}
 
-		o.Log().Infof("Bootstrapping host %s", hostname)
-		//Upload files via ssh
-		for fileName, fileData := range o.transferFiles(chefData) {
-			err = sshClient.TransferFile(fileData, fileName, o.SSHUploadDir)
-			if o.assertBootstrap(s, c, oHost.ID, hostname, err) {
-				return false
+// deleteHosts removes servers and chef objects, saves -report and returns exit code
+func (o *NodeUP) deleteHosts(hostnames []string) int {
+	start := time.Now()
+	var mutex sync.Mutex
+	exit := 0
+	deleted := map[string]bool{}
+	p := o.NewPool()
+	for _, hostname := range hostnames {
+		hostname := hostname
+		started := p.Go(func() {
+			ok := o.deleteNode(hostname)
+			mutex.Lock()
+			deleted[hostname] = ok
+			if !ok {
+				exit = 1
 			}
+			mutex.Unlock()
+		})
+		if !started {
+			exit = 1
+			break
 		}
+	}
+	p.Wait()
+	return o.saveReport(start, deleteReports(hostnames, deleted), "", exit)
+}
 
-		if o.UsePrivateNetwork {
-			err = sshClient.TransferFile(o.createInterfacesFile(o.Gateway), "00-sc-network.yaml", o.SSHUploadDir)
-			if o.assertBootstrap(s, c, oHost.ID, hostname, err) {
-				return false
-			}
-			for _, command := range o.configureDefaultGateway() {
-				err = sshClient.RunCommandPipe(command, outFile)
-				if o.assertBootstrap(s, c, oHost.ID, hostname, err) {
-					return false
-				}
-			}
-		}
+// deleteNode removes server and chef node with client
+func (o *NodeUP) deleteNode(hostname string) bool {
+	ok := true
+	serverID, err := o.Openstack.IDFromName(hostname)
+	if err != nil {
+		o.Log().Errorf("Can't retrive serverID: %s", err)
+	}
+	err = o.Openstack.DeleteServer(serverID)
+	if err != nil {
+		o.Log().Errorf("Server %s delete problem openstack", hostname)
+		ok = false
+	} else {
+		o.Log().Infof("Server %s successfully deleted from openstack", hostname)
+	}
+	if ok {
+		o.removeState(hostname)
+	}
+	if o.Chef == nil {
+		return ok
+	}
+	_, err = o.Chef.CleanupNode(hostname, hostname)
+	if err != nil {
+		o.Log().Errorf("Server %s delete problem chef", hostname)
+		o.Log().Error(err)
+		ok = false
+	} else {
+		o.Log().Infof("Server %s successfully deleted from chef", hostname)
+	}
+	return ok
+}
 
-		//Run command via ssh
-		for _, command := range o.runCommands(o.SSHUploadDir, o.ChefVersion, o.ChefEnvironment) {
-			err = sshClient.RunCommandPipe(command, outFile)
-			if o.assertBootstrap(s, c, oHost.ID, hostname, err) {
-				return false
-			}
-		}
+// NewHost returns host description with run-list, environment and zone from command line
+func (o *NodeUP) NewHost(hostname string) *Host {
+	return &Host{
+		Hostname:         hostname,
+		ChefRunList:      chef.RunList(o.splitList(o.ChefRole), o.splitList(o.ChefRecipes)),
+		ChefAttributes:   o.ChefAttributes,
+		ChefEnvironment:  o.ChefEnvironment,
+		ChefPolicyName:   o.ChefPolicyName,
+		ChefPolicyGroup:  o.ChefPolicyGroup,
+		AvailabilityZone: o.AvailabilityZone,
+		LogFile:          o.LogDir + "/" + hostname + ".log",
 	}
-	return true
 }
 
+// HandleSignals stops gracefully on first SIGINT or SIGTERM and exits on second
+func (o *NodeUP) HandleSignals() {
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		s := <-c
+		o.Log().WithField("signal", s.String()).Warn("received signal, send it again to exit immediately")
+		o.Stop()
+
+		s = <-c
+		o.Log().WithField("signal", s.String()).Error("received signal, exiting without cleanup")
+		o.Journal.Report()
+		os.Exit(1)
+	}()
+}
+
+// Stop prevents new work, in-flight steps are cancelled after grace period
 func (o *NodeUP) Stop() {
-	o.Log().Info("shutting things down")
-	close(o.StopCh)
-	os.Exit(0)
+	o.stopOnce.Do(func() {
+		o.Log().Infof("shutting things down, waiting %d seconds for in-flight steps", o.ShutdownGrace)
+		close(o.StopCh)
+		time.AfterFunc(time.Duration(o.ShutdownGrace)*time.Second, func() {
+			o.Log().Warn("grace period is over, cancelling in-flight steps")
+			o.cancel()
+		})
+	})
+}
+
+// Stopped reports new work shouldn't be started
+func (o *NodeUP) Stopped() bool {
+	select {
+	case <-o.StopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// Context is cancelled when grace period after Stop is over
+func (o *NodeUP) Context() context.Context {
+	return o.ctx
+}
+
+// sleep waits d or until ctx is cancelled
+func (o *NodeUP) sleep(d time.Duration) error {
+	select {
+	case <-o.ctx.Done():
+		return o.ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
 }
 
 func (o *NodeUP) Log() *log.Entry {
@@ -197,39 +286,66 @@ func (o *NodeUP) Version() string {
 	return o.Ver
 }
 
-func (o *NodeUP) nameGenerator(prefix string, count int) []string {
-
-	o.Log().Debugf("Generation hostname for %d hosts", count)
+// Hostnames generates count names from template, h gives {role}, {env} and {az}
+// Reserved names are taken by hosts which servers are not created yet
+func (o *NodeUP) Hostnames(template string, count int, h *Host, reserved ...string) ([]string, error) {
+	existing, err := o.existingNames()
+	if err != nil {
+		return nil, err
+	}
+	return o.naming(h).Generate(template, count, append(existing, reserved...))
+}
 
-	var result []string
+func (o *NodeUP) naming(h *Host) *naming.Naming {
+	return naming.New(o, naming.Config{
+		Vars:         nameVars(h),
+		RandomLength: o.PrefixCharts,
+	})
+}
 
-	req := garbler.PasswordStrengthRequirements{
-		MinimumTotalLength: 5,
-		MaximumTotalLength: 5,
-		Uppercase:          0,
-		Digits:             2,
-		Punctuation:        0,
+// existingNames are servers and chef nodes which new hosts shouldn't collide with
+func (o *NodeUP) existingNames() ([]string, error) {
+	servers, err := o.Openstack.GetServers()
+	if err != nil {
+		return nil, fmt.Errorf("can't list servers: %s", err)
+	}
+	var names []string
+	for _, s := range servers {
+		names = append(names, s.Name)
 	}
 
-	//Infinity loop for find duplicates
-	i := 0
-	for {
-		if i == count {
-			break
-		}
-		s, err := garbler.NewPassword(&req)
+	if o.Chef != nil {
+		nodes, err := o.Chef.NodeNames()
 		if err != nil {
-			o.Log().Errorf("Can't generate prefix for hostname: %s", err)
-		}
-		if !contains(result, s) {
-			result = append(result, strings.Replace(prefix, "*", s, -1))
-			i++
+			return nil, fmt.Errorf("can't list chef nodes: %s", err)
 		}
+		names = append(names, nodes...)
 	}
-	return result
+	return names, nil
 }
 
-func (o *NodeUP) GetAddress(addresses map[string]interface{}) []string {
+// nameVars are hostname template placeholders, policy replaces role and environment
+func nameVars(h *Host) map[string]string {
+	roles, _ := chef.ParseRunList(h.ChefRunList)
+	role := h.ChefPolicyName
+	if len(roles) > 0 {
+		role = roles[0]
+	}
+	env := h.ChefEnvironment
+	if h.ChefPolicyGroup != "" {
+		env = h.ChefPolicyGroup
+	}
+	return map[string]string{
+		"role":   role,
+		"env":    env,
+		"policy": h.ChefPolicyName,
+		"group":  h.ChefPolicyGroup,
+		"az":     h.AvailabilityZone,
+	}
+}
+
+// GetAddress returns public addresses or private ones when server has no public
+func (o *NodeUP) GetAddress(addresses map[string]interface{}) ([]string, bool) {
 	var public []string
 	var private []string
 
@@ -250,15 +366,13 @@ func (o *NodeUP) GetAddress(addresses map[string]interface{}) []string {
 
 	if len(public) > 0 {
 		o.Log().Debugf("Found public ip's: %s", public)
-		return public
-	} else {
-		o.UsePrivateNetwork = true
-		return private
+		return public, false
 	}
+	return private, true
 }
 
-func sshConnect(address string) error {
-	conn, err := net.DialTimeout("tcp", address+":22", 5*time.Second)
+func (o *NodeUP) sshConnect(address string) error {
+	conn, err := o.Bastion.Dial("tcp", address+":22", 5*time.Second)
 	if err != nil {
 		return err
 	}
@@ -268,22 +382,86 @@ func sshConnect(address string) error {
 
 func (o *NodeUP) checkSSHPort(address string) bool {
 	o.Log().Infof("Waiting SSH on host %s", address)
-	time.Sleep(10 * time.Second) //Waiting ssh daemon
+	if o.sleep(10*time.Second) != nil { //Waiting ssh daemon
+		return false
+	}
 	for i := 0; i <= o.SSHWaitRetry; i++ {
-		err := sshConnect(address)
+		err := o.sshConnect(address)
 		if err != nil {
 			o.Log().Warnf("Cannot connect to host %s #%d: %s", address, i+1, err.Error())
 		} else {
 			return true
 		}
 		o.Log().Infof("Retries left %d", o.SSHWaitRetry-i)
-		time.Sleep(10 * time.Second)
+		if o.sleep(10*time.Second) != nil {
+			return false
+		}
 	}
 	o.Log().Errorf("Can't connect to host %s via ssh", address)
 
 	return false
 }
 
+// SSHConfig returns SSH auth, host keys and bastion settings
+func (o *NodeUP) SSHConfig() ssh.Config {
+	return ssh.Config{
+		Auth:     o.sshAuth(),
+		HostKeys: o.hostKeys(),
+		Bastion:  o.Bastion,
+	}
+}
+
+// sshAuth returns SSH authentication methods in configured order
+func (o *NodeUP) sshAuth() ssh.Auth {
+	certPath := o.SSHCertPath
+	if certPath == "" && o.SSHKeyPath != "" {
+		certPath = o.SSHKeyPath + "-cert.pub"
+	}
+	return ssh.Auth{
+		Methods:    o.splitList(o.SSHAuthMethods),
+		KeyPath:    o.SSHKeyPath,
+		Passphrase: o.SSHKeyPassphrase,
+		CertPath:   certPath,
+	}
+}
+
+// hostKeys returns SSH host key verification settings
+func (o *NodeUP) hostKeys() ssh.HostKeys {
+	return ssh.HostKeys{
+		Policy:     o.SSHHostKeyPolicy,
+		KnownHosts: o.SSHKnownHosts,
+	}
+}
+
+// pinHostKeys saves host keys printed by cloud-init to console log before first connect
+func (o *NodeUP) pinHostKeys(serverID string, address string) error {
+	if o.SSHHostKeyPolicy == ssh.PolicyInsecure {
+		return nil
+	}
+
+	for i := 0; i <= o.SSHWaitRetry; i++ {
+		console, err := o.Openstack.ConsoleOutput(serverID, 0)
+		if err != nil {
+			o.Log().Warnf("Can't get console log for %s: %s", address, err)
+			break
+		}
+		if keys := ssh.ConsoleHostKeys(console); len(keys) > 0 {
+			o.Log().Infof("Pin %d host keys for %s from console log", len(keys), address)
+			return ssh.Pin(o.SSHKnownHosts, address, keys)
+		}
+		o.Log().Debugf("Waiting host keys for %s in console log", address)
+		if err := o.sleep(10 * time.Second); err != nil {
+			return err
+		}
+	}
+
+	if o.SSHHostKeyPolicy == ssh.PolicyStrict {
+		return fmt.Errorf("host keys for %s not found in console log", address)
+	}
+	o.Log().Warnf("Host keys for %s not found in console log, new key will be accepted", address)
+	return nil
+}
+
 func (o *NodeUP) deleteChefNode(hostname string) {
 	cmdName := "knife"
 	cmdArgs := []string{"node", "delete", hostname, "-y"}
@@ -302,108 +480,132 @@ func (o *NodeUP) deleteChefNode(hostname string) {
 	}
 }
 
-func (o *NodeUP) isWildcard(string string) bool {
-	if strings.ContainsAny(string, "*") {
-		return true
-	} else {
-		return false
+// removers delete resources recorded in journal
+func (o *NodeUP) removers() map[string]rollback.Remover {
+	return map[string]rollback.Remover{
+		rollback.KindServer: func(r rollback.Resource) error {
+			return o.Openstack.RemoveServer(r.ID)
+		},
+		rollback.KindKeyPair: func(r rollback.Resource) error {
+			return o.Openstack.DeleteKeyPair(r.Name)
+		},
+		rollback.KindChefNode: func(r rollback.Resource) error {
+			return o.Chef.RemoveNode(r.Name)
+		},
+		rollback.KindChefClient: func(r rollback.Resource) error {
+			return o.Chef.RemoveClient(r.Name)
+		},
 	}
 }
 
-func (o *NodeUP) assertBootstrap(openstack *openstack.Openstack, chefClient *chef.ChefClient, id string, hostname string, err error) (exit bool) {
-	if o.IgnoreFail {
-		o.Log().Warnf("Host %s bootstrap is fail. Skipped", hostname)
-		return false
+// createServer records admin keypair and server in journal
+func (o *NodeUP) createServer(h *Host, userData []byte) error {
+	created, err := o.Openstack.EnsureKeyPair()
+	if created {
+		o.Journal.Add(rollback.Resource{Kind: rollback.KindKeyPair, Name: o.OSKeyName})
 	}
-
 	if err != nil {
-		o.Log().Errorf("Bootstrap error: %s", err)
-		host := openstack.DeleteIfError(id, err)
-		chefClient, err := chefClient.CleanupNode(hostname, hostname)
-		if err != nil {
-			o.Log().Errorf("Chef cleanup node error %s", err)
-			o.Exitcode = 1
-			return true
-		}
-
-		if !host && !chefClient {
-			o.Log().Errorf("Can't cleanup node %s", hostname)
-			o.Exitcode = 1
-			return true
-		}
-		o.Exitcode = 1
-		return true
+		return err
 	}
-	return false
-}
 
-func (o *NodeUP) deleteHost(openstack *openstack.Openstack, chefClient *chef.ChefClient, id string, hostname string) {
-	err := openstack.DeleteServer(id)
+	server, err := o.Openstack.CreateServer(h.Hostname, o.OSRetryTimeout, o.OSGroupID, o.DefineNetworks, h.AvailabilityZone, userData)
 	if err != nil {
-		o.Log().Errorf("Openstack delete server error %s", err)
+		return err
 	}
-	_, err = chefClient.CleanupNode(hostname, hostname)
-	if err != nil {
-		o.Log().Errorf("Chef cleanup node error %s", err)
+	h.ServerID = server.ID
+	o.Journal.Add(rollback.Resource{Kind: rollback.KindServer, ID: server.ID, Name: h.Hostname, Host: h.Hostname})
+	if h.Created != nil {
+		h.Created(h)
 	}
+	return nil
 }
 
-func (o *NodeUP) configureDefaultGateway() []string {
-	data := []string{
-		"sudo mv 00-sc-network.yaml /etc/netplan/",
-		"sudo netplan generate",
-		"sudo netplan apply",
+// recordChef adds node and client to journal, they are created by API or first chef-client run
+func (o *NodeUP) recordChef(h *Host) {
+	if o.Provisioner != "chef" || o.Chef == nil {
+		return
 	}
-	return data
+	o.Journal.Add(rollback.Resource{Kind: rollback.KindChefNode, Name: h.Hostname, Host: h.Hostname})
+	o.Journal.Add(rollback.Resource{Kind: rollback.KindChefClient, Name: h.Hostname, Host: h.Hostname})
 }
 
-func (o *NodeUP) transferFiles(chef *chef.Chef) map[string][]byte {
-	data := make(map[string][]byte)
-	data["bootstrap.json"] = chef.BootstrapJson
-	data["validation.pem"] = chef.ValidationPem
-	data["client.rb"] = chef.ChefConfig
-	data["hosts"] = chef.Hosts
-
-	return data
+// failHost removes host resources unless -ignoreFail is set
+func (o *NodeUP) failHost(hostname string, err error) {
+	o.Log().Errorf("Bootstrap error on host %s: %s", hostname, err)
+	if o.IgnoreFail {
+		o.Journal.Keep(hostname)
+		o.Log().Warnf("Host %s bootstrap is fail. Skipped", hostname)
+		return
+	}
+	if !o.Journal.Rollback(hostname) {
+		o.Log().Errorf("Can't cleanup node %s", hostname)
+	}
 }
 
-func (o *NodeUP) runCommands(dir string, version string, environment string) []string {
-	data := []string{
-		"sudo mv hosts /etc/hosts && sudo hostname -F /etc/hostname",
-		"sudo mkdir -p /etc/chef",
-		"wget -q https://omnitruck.chef.io/install.sh && sudo bash ./install.sh -v " + version + " && rm install.sh",
-		"sudo chmod 0600 " + dir + "/validation.pem",
-		"sudo chef-client -c " + dir + "/client.rb -E " + environment + " -j " + dir + "/bootstrap.json",
-		"sudo rm " + dir + "/client.rb && sudo rm " + dir + "/validation.pem && rm " + dir + "/bootstrap.json",
-		"sudo chef-client",
+func (o *NodeUP) configureDefaultGateway(dir string) []ssh.Command {
+	timeout := time.Duration(o.SSHCommandTimeout) * time.Minute
+	data := []ssh.Command{
+		{Name: "netplan-config", Cmd: "sudo mv " + dir + "/00-sc-network.yaml /etc/netplan/", Timeout: timeout},
+		{Name: "netplan-generate", Cmd: "sudo netplan generate", Timeout: timeout},
+		{Name: "netplan-apply", Cmd: "sudo netplan apply", Timeout: timeout},
 	}
+	return data
+}
 
-	var packagesToInstall string
-
-	if len(o.PackagesToInstallBeforeChef) != 0 {
-		packagesToInstall = strings.Replace(o.PackagesToInstallBeforeChef, " ", "", -1)
-		packagesToInstall = strings.Replace(o.PackagesToInstallBeforeChef, ",", " ", -1)
-
-		tempData := make([]string, len(data)-2)
-		copy(tempData, data[2:])
-		data = append(data[:2], fmt.Sprintf("sudo apt-get -y install %s", packagesToInstall))
-
-		for _, command := range tempData {
-			data = append(data, command)
-		}
-	}
+func (o *NodeUP) newProvisioner(h *Host, uploadDir string) (provisioner.Provisioner, error) {
+	config := provisioner.Config{
+		Hostname:  h.Hostname,
+		Domain:    o.Domain,
+		UploadDir: uploadDir,
+		Packages:  o.PackagesToInstallBeforeChef,
+
+		CommandTimeout: time.Duration(o.SSHCommandTimeout) * time.Minute,
+		Retries:        o.SSHCommandRetries,
+	}
+
+	switch o.Provisioner {
+	case "chef":
+		return provisioner.NewChef(o, config, provisioner.ChefConfig{
+			ServerUrl:     o.ChefServerUrl,
+			ValidationPem: o.ChefValidationPem,
+			Version:       o.ChefVersion,
+			Environment:   h.ChefEnvironment,
+			Bootstrap: &chef.Bootstrap{
+				RunList:     h.ChefRunList,
+				Attributes:  h.ChefAttributes,
+				PolicyName:  h.ChefPolicyName,
+				PolicyGroup: h.ChefPolicyGroup,
+			},
+			Validatorless: o.ChefValidatorless,
+			Client:        o.Chef,
+		}), nil
+	case "shell":
+		return provisioner.NewShell(o, config, o.ProvisionScript), nil
+	case "ansible":
+		return provisioner.NewAnsible(o, config, o.AnsibleRepo, o.AnsibleBranch, o.AnsiblePlaybook), nil
+	}
+	return nil, fmt.Errorf("unknown provisioner %s", o.Provisioner)
+}
 
-	return data
+func (o *NodeUP) newVerify() *verify.Verify {
+	return verify.New(o, verify.Config{
+		TCP:     o.splitList(o.VerifyTCP),
+		HTTP:    o.splitList(o.VerifyHTTP),
+		Command: o.VerifyCommand,
+		Timeout: time.Duration(o.VerifyTimeout) * time.Minute,
+	}, o.Bastion.Dial)
 }
 
-func contains(slice []string, item string) bool {
-	set := make(map[string]struct{}, len(slice))
-	for _, s := range slice {
-		set[s] = struct{}{}
+// verifyChef checks node reported with run-list and environment of host
+func (o *NodeUP) verifyChef(h *Host) error {
+	if o.Provisioner != "chef" || o.Chef == nil {
+		return nil
 	}
-
-	_, ok := set[item]
-	return ok
+	return o.Chef.CheckNode(h.Hostname, h.ChefEnvironment, &chef.Bootstrap{
+		RunList:     h.ChefRunList,
+		PolicyName:  h.ChefPolicyName,
+		PolicyGroup: h.ChefPolicyGroup,
+	})
 }
 
 func (o *NodeUP) privateIP(ip string) bool {
@@ -475,3 +677,13 @@ func (o *NodeUP) createInterfacesFile(gateway string) []byte {
 func (o *NodeUP) DeleteWhitespaces(string string) string {
 	return strings.Replace(string, " ", "", -1)
 }
+
+func (o *NodeUP) splitList(list string) []string {
+	var result []string
+	for _, item := range strings.Split(o.DeleteWhitespaces(list), ",") {
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,291 @@
+package nodeup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/onetwotrip/nodeup/pkg/openstack"
+	"github.com/onetwotrip/nodeup/pkg/report"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeOpenstack creates servers in memory, hosts with "private" in name get private address only
+type fakeOpenstack struct {
+	openstack.Cloud
+
+	mutex   sync.Mutex
+	servers map[string]string
+	removed []string
+}
+
+func (f *fakeOpenstack) EnsureKeyPair() (bool, error) {
+	return false, nil
+}
+
+func (f *fakeOpenstack) CreateServer(hostname string, timeout int, group string, networks string, availabilityZone string, userData []byte) (*servers.Server, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	id := fmt.Sprintf("id-%d", len(f.servers))
+	f.servers[id] = hostname
+	return &servers.Server{ID: id, Name: hostname}, nil
+}
+
+func (f *fakeOpenstack) WaitServerActive(ctx context.Context, id string, timeout int) (*servers.Server, error) {
+	f.mutex.Lock()
+	hostname := f.servers[id]
+	f.mutex.Unlock()
+
+	if strings.Contains(hostname, "fail") {
+		return nil, errors.New("server status is ERROR")
+	}
+	addr := "8.8.8." + strings.TrimPrefix(id, "id-")
+	if strings.Contains(hostname, "private") {
+		addr = "192.168.0." + strings.TrimPrefix(id, "id-")
+	}
+	return &servers.Server{ID: id, Name: hostname, Addresses: map[string]interface{}{
+		"net": []interface{}{map[string]interface{}{"addr": addr}},
+	}}, nil
+}
+
+func (f *fakeOpenstack) GetServers() ([]servers.Server, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var result []servers.Server
+	for id, name := range f.servers {
+		status := "ACTIVE"
+		if strings.Contains(name, "fail") {
+			status = "ERROR"
+		}
+		result = append(result, servers.Server{ID: id, Name: name, Status: status})
+	}
+	return result, nil
+}
+
+func (f *fakeOpenstack) GetServerDetail(sid string) (openstack.Server, error) {
+	return openstack.Server{ID: sid, HypervisorName: "hv1"}, nil
+}
+
+func (f *fakeOpenstack) RemoveServer(sid string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.removed = append(f.removed, f.servers[sid])
+	return nil
+}
+
+func (f *fakeOpenstack) IDFromName(hostname string) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for id, name := range f.servers {
+		if name == hostname {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("server %s is not found", hostname)
+}
+
+func (f *fakeOpenstack) DeleteServer(sid string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.servers[sid]; !ok {
+		return errors.New("server is not found")
+	}
+	f.removed = append(f.removed, f.servers[sid])
+	delete(f.servers, sid)
+	return nil
+}
+
+func TestBootstrapHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeup")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	// SSH steps are replaced with check of host address
+	defaultSteps := steps
+	defer func() { steps = defaultSteps }()
+	steps = []step{
+		defaultSteps[0],
+		defaultSteps[1],
+		{name: StepNetwork, run: func(b *bootstrap) error {
+			h := b.state.Host
+			if h.PrivateNetwork != strings.Contains(h.Hostname, "private") {
+				return fmt.Errorf("wrong network mode for %s", h.Address())
+			}
+			return nil
+		}},
+	}
+
+	fake := &fakeOpenstack{servers: map[string]string{}}
+	o := New("test", logrus.NewEntry(logrus.New()))
+	o.Openstack = fake
+	o.LogDir = dir
+	o.Concurrency = 4
+
+	var hosts []*Host
+	for i := 0; i < 8; i++ {
+		hosts = append(hosts, o.NewHost(fmt.Sprintf("public-%d", i)))
+		hosts = append(hosts, o.NewHost(fmt.Sprintf("private-%d", i)))
+	}
+	assert.Equal(t, 0, o.bootstrapHosts(hosts))
+	for _, h := range hosts {
+		assert.True(t, h.Result.Success, h.Hostname)
+		assert.Equal(t, strings.HasPrefix(h.Hostname, "private"), h.PrivateNetwork, h.Hostname)
+		assert.Equal(t, h.LogFile, dir+"/"+h.Hostname+".log")
+	}
+
+	failed := o.NewHost("fail-0")
+	assert.Equal(t, 1, o.bootstrapHosts([]*Host{o.NewHost("public-8"), failed}))
+	assert.False(t, failed.Result.Success)
+	assert.Equal(t, StepWaitActive, failed.Result.Step)
+	assert.Equal(t, "server status is ERROR", failed.Result.Error)
+	assert.Equal(t, []string{"fail-0"}, fake.removed)
+	assert.Equal(t, 2, len(failed.Result.Steps))
+
+	r := o.hostReport(failed)
+	assert.Equal(t, "failed", r.Status)
+	assert.Equal(t, StepWaitActive, r.FailedStep)
+	assert.Equal(t, "", r.Hypervisor)
+
+	r = o.hostReport(hosts[1])
+	assert.Equal(t, "success", r.Status)
+	assert.Equal(t, "hv1", r.Hypervisor)
+	assert.Equal(t, hosts[1].Addresses, r.Addresses)
+	assert.Equal(t, []string{StepCreate, StepWaitActive, StepNetwork}, []string{r.Steps[0].Name, r.Steps[1].Name, r.Steps[2].Name})
+
+	assert.Equal(t, "skipped", o.hostReport(o.NewHost("public-9")).Status)
+}
+
+func TestFailKeepsActiveServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeup")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	defaultSteps := steps
+	defer func() { steps = defaultSteps }()
+	steps = []step{
+		defaultSteps[0],
+		defaultSteps[1],
+		{name: StepNetwork, run: func(b *bootstrap) error {
+			return errors.New("apt mirror is not available")
+		}},
+	}
+
+	fake := &fakeOpenstack{servers: map[string]string{}}
+	o := New("test", logrus.NewEntry(logrus.New()))
+	o.Openstack = fake
+	o.LogDir = dir
+	o.Concurrency = 1
+
+	// Failure after server is active keeps it for -resume
+	h := o.NewHost("search-01")
+	var created string
+	h.Created = func(h *Host) {
+		created = h.ServerID
+	}
+	assert.False(t, o.BootstrapHost(h))
+	assert.NotEqual(t, "", created)
+	assert.Equal(t, h.ServerID, created)
+	assert.Equal(t, []string(nil), fake.removed)
+	state, err := o.loadState("search-01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, StepNetwork, state.FailedStep)
+	assert.Equal(t, h.ServerID, state.Host.ServerID)
+	assert.Equal(t, "hv1", o.hostReport(h).Hypervisor)
+
+	// Explicit cleanup removes server and state
+	assert.Equal(t, 0, o.deleteHosts([]string{"search-01"}))
+	assert.Equal(t, []string{"search-01"}, fake.removed)
+	_, err = o.loadState("search-01")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestHostnames(t *testing.T) {
+	o := New("test", logrus.NewEntry(logrus.New()))
+	o.Openstack = &fakeOpenstack{servers: map[string]string{
+		"id-1": "search-production-03",
+		"id-2": "search-staging-08",
+	}}
+	o.ChefRole = "search,backend"
+	o.ChefEnvironment = "production"
+
+	names, err := o.Hostnames("{role}-{env}-{seq:02}", 2, o.NewHost(""))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"search-production-04", "search-production-05"}, names)
+
+	_, err = o.Hostnames("search-production-03", 1, o.NewHost(""))
+	assert.EqualError(t, err, "host search-production-03 already exists")
+
+	o.ChefPolicyName = "search"
+	o.ChefPolicyGroup = "staging"
+	o.ChefRole = ""
+	names, err = o.Hostnames("{role}-{env}-{seq:02}", 1, o.NewHost(""))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"search-staging-09"}, names)
+
+	// Names of hosts being set up are skipped
+	names, err = o.Hostnames("{role}-{env}-{seq:02}", 1, o.NewHost(""), "search-staging-09")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"search-staging-10"}, names)
+}
+
+func TestReportExitPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeup")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	o := New("test", logrus.NewEntry(logrus.New()))
+	o.Openstack = &fakeOpenstack{servers: map[string]string{"id-1": "search-01"}}
+	o.Concurrency = 2
+	o.Report = dir + "/report.json"
+	load := func() *report.Report {
+		data, err := ioutil.ReadFile(o.Report)
+		assert.Equal(t, nil, err)
+		r := &report.Report{}
+		assert.Equal(t, nil, json.Unmarshal(data, r))
+		return r
+	}
+
+	assert.Equal(t, 1, o.preflightReport(time.Now(), []error{errors.New("flavor 4x8192 is not found"), errors.New("host search-01 already exists")}))
+	r := load()
+	assert.Equal(t, "create", r.Mode)
+	assert.False(t, r.Success)
+	assert.Equal(t, "preflight check failed: flavor 4x8192 is not found; host search-01 already exists", r.Error)
+
+	o.DeleteNodes = "search-01,search-02"
+	assert.Equal(t, 1, o.deleteHosts(strings.Split(o.DeleteNodes, ",")))
+	r = load()
+	assert.Equal(t, "delete", r.Mode)
+	assert.False(t, r.Success)
+	assert.Equal(t, 2, len(r.Hosts))
+	assert.Equal(t, report.StatusSuccess, r.Hosts[0].Status)
+	assert.Equal(t, report.StatusFailed, r.Hosts[1].Status)
+	o.DeleteNodes = ""
+
+	o.Resume = "search-03"
+	o.LogDir = dir
+	assert.Equal(t, 1, o.resume(o.Resume))
+	r = load()
+	assert.Equal(t, "resume", r.Mode)
+	assert.False(t, r.Success)
+	assert.Contains(t, r.Error, "can't resume host search-03")
+	o.Resume = ""
+
+	o.Ensure = true
+	o.Name = "backend-{seq:02}"
+	assert.Equal(t, 0, o.ensure())
+	r = load()
+	assert.Equal(t, "ensure", r.Mode)
+	assert.True(t, r.Success)
+
+	// Exit code of the run fails report without failed hosts
+	assert.Equal(t, 1, o.saveReport(time.Now(), nil, "", 1))
+	assert.Equal(t, "run failed, see log", load().Error)
+}
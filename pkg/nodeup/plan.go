@@ -0,0 +1,64 @@
+package nodeup
+
+import (
+	"github.com/onetwotrip/nodeup/pkg/plan"
+	"os"
+	"strings"
+)
+
+// PrintPlan writes plan to stdout in -plan-format
+func (o *NodeUP) PrintPlan(p *plan.Plan) {
+	err := p.Print(os.Stdout, o.PlanFormat)
+	if err != nil {
+		o.Log().Fatal(err)
+	}
+}
+
+func (o *NodeUP) createPlan(hostnames []string) *plan.Plan {
+	p := plan.New("create")
+
+	// Preflight has already resolved all of them
+	flavorID, _ := o.Openstack.FlavorID()
+	imageID, _ := o.Openstack.ImageID()
+	networkIDs, _ := o.Openstack.NetworkIDs(o.DefineNetworks)
+
+	for _, hostname := range hostnames {
+		h := o.NewHost(hostname)
+		p.Create = append(p.Create, plan.Create{
+			Hostname:         h.Hostname,
+			Flavor:           o.OSFlavorName,
+			FlavorID:         flavorID,
+			Image:            o.Image,
+			ImageID:          imageID,
+			Networks:         strings.Split(o.DefineNetworks, ","),
+			NetworkIDs:       networkIDs,
+			AvailabilityZone: h.AvailabilityZone,
+			Group:            o.OSGroupID,
+			Provisioner:      o.Provisioner,
+			RunList:          h.ChefRunList,
+			Environment:      h.ChefEnvironment,
+			PolicyName:       h.ChefPolicyName,
+			PolicyGroup:      h.ChefPolicyGroup,
+		})
+	}
+	return p
+}
+
+func (o *NodeUP) deletePlan(hostnames []string) *plan.Plan {
+	p := plan.New("delete")
+	for _, hostname := range hostnames {
+		d := plan.Delete{
+			Hostname: hostname,
+		}
+		serverID, err := o.Openstack.IDFromName(hostname)
+		if err != nil {
+			d.Error = err.Error()
+		}
+		d.ServerID = serverID
+		if o.Chef != nil {
+			d.ChefNode, d.ChefClient = o.Chef.Exists(hostname)
+		}
+		p.Delete = append(p.Delete, d)
+	}
+	return p
+}
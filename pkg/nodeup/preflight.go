@@ -0,0 +1,61 @@
+package nodeup
+
+import (
+	"fmt"
+	"github.com/onetwotrip/nodeup/pkg/chef"
+	"strings"
+	"time"
+)
+
+// Preflight checks Chef and Openstack objects before any server is created
+func (o *NodeUP) Preflight(h *Host) []error {
+	var errs []error
+
+	if o.Chef != nil {
+		roles, recipes := chef.ParseRunList(h.ChefRunList)
+		for _, role := range roles {
+			if err := o.Chef.CheckRole(role); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if h.ChefEnvironment != "" {
+			if err := o.Chef.CheckEnvironment(h.ChefEnvironment); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if len(recipes) > 0 {
+			missing, err := o.Chef.CheckRecipes(recipes)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("chef recipes: %s", err))
+			} else if len(missing) > 0 {
+				errs = append(errs, fmt.Errorf("chef recipes not found: %s", strings.Join(missing, ",")))
+			}
+		}
+	}
+
+	if _, err := o.Openstack.FlavorID(); err != nil {
+		errs = append(errs, fmt.Errorf("flavor %s: %s", o.OSFlavorName, err))
+	}
+	if _, err := o.Openstack.ImageID(); err != nil {
+		errs = append(errs, fmt.Errorf("image %s: %s", o.Image, err))
+	}
+	if _, err := o.Openstack.NetworkIDs(o.DefineNetworks); err != nil {
+		errs = append(errs, fmt.Errorf("networks %s: %s", o.DefineNetworks, err))
+	}
+	if err := o.Openstack.CheckKeyPair(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// preflightReport logs errors and saves failed -report, returns exit code
+func (o *NodeUP) preflightReport(started time.Time, errs []error) int {
+	o.Log().Errorf("Preflight check failed with %d error(s), nothing was created", len(errs))
+	var messages []string
+	for _, err := range errs {
+		o.Log().Errorf(" - %s", err)
+		messages = append(messages, err.Error())
+	}
+	return o.saveReport(started, nil, "preflight check failed: "+strings.Join(messages, "; "), 1)
+}
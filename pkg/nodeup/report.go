@@ -0,0 +1,119 @@
+package nodeup
+
+import (
+	"github.com/onetwotrip/nodeup/pkg/report"
+	"time"
+)
+
+// saveReport writes hosts and error of the whole run to -report and returns exit code
+// Failed run is never reported as success, failed save makes exit code non-zero
+func (o *NodeUP) saveReport(started time.Time, hosts []report.Host, failure string, exitcode int) int {
+	if o.Report == "" {
+		return exitcode
+	}
+	r := report.New(o.reportMode(), started)
+	for _, h := range hosts {
+		r.Add(h)
+	}
+	if failure == "" && exitcode != 0 && r.Success {
+		failure = "run failed, see log"
+	}
+	if failure != "" {
+		r.Fail(failure)
+	}
+	r.Duration = time.Since(started).Seconds()
+
+	if err := r.Save(o.Report, o.ReportFormat); err != nil {
+		o.Log().Errorf("Can't save report %s: %s", o.Report, err)
+		return 1
+	}
+	o.Log().Infof("Report saved to %s", o.Report)
+	return exitcode
+}
+
+// reportMode is -deleteNodes, -resume, -ensure or create
+func (o *NodeUP) reportMode() string {
+	switch {
+	case o.DeleteNodes != "":
+		return "delete"
+	case o.Resume != "":
+		return "resume"
+	case o.Ensure:
+		return "ensure"
+	}
+	return "create"
+}
+
+func (o *NodeUP) hostReports(hosts []*Host) []report.Host {
+	var reports []report.Host
+	for _, h := range hosts {
+		reports = append(reports, o.hostReport(h))
+	}
+	return reports
+}
+
+// deleteReports returns status of every host, hosts without result were not started
+func deleteReports(hostnames []string, deleted map[string]bool) []report.Host {
+	var reports []report.Host
+	for _, hostname := range hostnames {
+		r := report.Host{Hostname: hostname, Status: report.StatusSkipped}
+		ok, done := deleted[hostname]
+		switch {
+		case done && ok:
+			r.Status = report.StatusSuccess
+		case done:
+			r.Status = report.StatusFailed
+			r.FailedStep = "delete"
+			r.Error = "can't delete server or chef node, see log"
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+func (o *NodeUP) hostReport(h *Host) report.Host {
+	r := report.Host{
+		Hostname:  h.Hostname,
+		ServerID:  h.ServerID,
+		Addresses: h.Addresses,
+		Flavor:    o.OSFlavorName,
+		Status:    report.StatusSkipped,
+		Log:       h.LogFile,
+	}
+	if o.JenkinsMode {
+		r.LogURL = o.JenkinsLogURL + h.Hostname + ".log"
+	}
+	if h.Result == nil {
+		return r
+	}
+
+	switch {
+	case h.Result.Success:
+		r.Status = report.StatusSuccess
+	case h.Result.Interrupted:
+		r.Status = report.StatusInterrupted
+	default:
+		r.Status = report.StatusFailed
+	}
+	r.FailedStep = h.Result.Step
+	r.Error = h.Result.Error
+	r.Duration = h.Result.Duration.Seconds()
+	for _, s := range h.Result.Steps {
+		r.Steps = append(r.Steps, report.Step{
+			Name:     s.Name,
+			Duration: s.Duration.Seconds(),
+			Error:    s.Error,
+		})
+	}
+
+	// Failed server is removed before it is active unless -ignoreFail
+	if h.ServerID != "" && (h.Result.Success || o.IgnoreFail || resumable(h.Result.Step)) {
+		server, err := o.Openstack.GetServerDetail(h.ServerID)
+		if err != nil {
+			o.Log().Debugf("Can't get hypervisor of host %s: %s", h.Hostname, err)
+		} else {
+			r.Hypervisor = server.HypervisorName
+		}
+	}
+	return r
+}
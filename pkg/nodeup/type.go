@@ -1,32 +1,37 @@
 package nodeup
 
 import (
+	"context"
 	"github.com/onetwotrip/nodeup/pkg/chef"
 	"github.com/onetwotrip/nodeup/pkg/openstack"
+	"github.com/onetwotrip/nodeup/pkg/rollback"
+	"github.com/onetwotrip/nodeup/pkg/ssh"
 	log "github.com/sirupsen/logrus"
 	"sync"
+	"time"
 )
 
 type NodeUP struct {
 	Ver     string
 	Logging *log.Entry
 
-	Openstack *openstack.Openstack
+	Openstack openstack.Cloud
 	Chef      *chef.ChefClient
-
-	Name              string
-	Domain            string
-	Image             string
-	User              string
-	Count             int
-	PrefixCharts      int
-	Concurrency       int
-	IgnoreFail        bool
-	LogDir            string
-	DefineNetworks    string
-	UsePrivateNetwork bool
-	Gateway           string
-	AvailabilityZone  string
+	// Journal of created resources removed on failure
+	Journal *rollback.Journal
+
+	Name             string
+	Domain           string
+	Image            string
+	User             string
+	Count            int
+	PrefixCharts     int
+	Concurrency      int
+	IgnoreFail       bool
+	LogDir           string
+	DefineNetworks   string
+	Gateway          string
+	AvailabilityZone string
 
 	OSAuthURL       string
 	OSTenantName    string
@@ -40,11 +45,24 @@ type NodeUP struct {
 	OSProjectID     string
 	OSRegionName    string
 	OSRetryTimeout  int
+	// OSRateLimit is max Openstack API requests per second
+	OSRateLimit float64
+	// Stagger in seconds between worker starts
+	Stagger int
 
 	SSHWaitRetry int
 
 	PackagesToInstallBeforeChef string
 
+	BootstrapMode       string
+	CloudInitTimeout    int
+	Provisioner         string
+	ProvisionScriptPath string
+	ProvisionScript     []byte
+	AnsibleRepo         string
+	AnsibleBranch       string
+	AnsiblePlaybook     string
+
 	ChefVersion        string
 	ChefServerUrl      string
 	ChefClientName     string
@@ -52,31 +70,116 @@ type NodeUP struct {
 	ChefKeyPem         []byte
 	ChefValidationPath string
 	ChefValidationPem  []byte
+	ChefValidatorless  bool
 	ChefEnvironment    string
 	ChefRole           string
+	ChefRecipes        string
+	ChefAttributesPath string
+	ChefAttributes     map[string]interface{}
+	ChefPolicyName     string
+	ChefPolicyGroup    string
 
 	JenkinsMode   bool
 	JenkinsLogURL string
 
-	SSHUser      string
-	SSHUploadDir string
+	SSHUser          string
+	SSHUploadDir     string
+	SSHHostKeyPolicy string
+	SSHKnownHosts    string
+	SSHAuthMethods   string
+	SSHKeyPath       string
+	SSHKeyPassphrase []byte
+	SSHCertPath      string
+	SSHBastion       string
+	Bastion          *ssh.Bastion
+	// SSHCommandTimeout in minutes for one bootstrap step
+	SSHCommandTimeout int
+	SSHCommandRetries int
+
+	// Checks after bootstrap
+	VerifyTCP     string
+	VerifyHTTP    string
+	VerifyCommand string
+	// VerifyTimeout in minutes for all checks
+	VerifyTimeout int
 
 	DeleteNodes string
+	Resume      string
+	// Ensure creates or deletes hosts matching Name up to Count
+	Ensure bool
+
+	DryRun     bool
+	PlanFormat string
+
+	// Report is path of run report in ReportFormat
+	Report       string
+	ReportFormat string
 
 	Exitcode int
 
 	Daemon bool
 
 	WebSSHUser string
+	JobsDB     string
 
 	//Migration
 	Migrate    bool
 	Rebalance  bool
 	Hosts      string
 	Hypervisor string
+	PlanOut    string
+	ApplyPlan  string
 
+	// StopCh is closed on Stop, new work is not started after it
 	StopCh    chan struct{}
 	WaitGroup sync.WaitGroup
+	// ShutdownGrace in seconds for in-flight steps after Stop
+	ShutdownGrace int
+	stopOnce      sync.Once
+
+	// ctx is cancelled when grace period after Stop is over
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Host describes a single server bootstrap
+type Host struct {
+	Hostname         string
+	ChefRunList      []string
+	ChefAttributes   map[string]interface{}
+	ChefEnvironment  string
+	ChefPolicyName   string
+	ChefPolicyGroup  string
+	AvailabilityZone string
+	LogFile          string
+
+	ServerID string
+	// Addresses for SSH, private ones only when server has no public
+	Addresses      []string
+	PrivateNetwork bool
+	// Result is set when bootstrap is finished
+	Result *Result `json:"-"`
+	// Created is called when server is created, long before Result
+	Created func(h *Host) `json:"-"`
+}
+
+// Result of host bootstrap
+type Result struct {
+	Success bool
+	// Step is the failed step
+	Step     string
+	Error    string
+	Duration time.Duration
+	// Steps are run steps with the failed one last
+	Steps []StepResult
+	// Interrupted by SIGINT or SIGTERM
+	Interrupted bool
+}
+
+type StepResult struct {
+	Name     string
+	Duration time.Duration
+	Error    string
 }
 
 type Interfaces struct {
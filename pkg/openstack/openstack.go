@@ -1,8 +1,10 @@
 package openstack
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/hypervisors"
@@ -21,15 +23,19 @@ import (
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 
+	"github.com/onetwotrip/nodeup/pkg/pool"
 	"github.com/patrickmn/go-cache"
+	"net/http"
 	"os"
 	"sort"
 	"strings"
-	"sync"
 	"time"
 )
 
-func New(nodeup nodeup.NodeUP, key string, keyName string, flavor string, image string) *Openstack {
+var _ Cloud = &Openstack{}
+
+// New connects to Openstack, rateLimit is max API requests per second, zero is unlimited
+func New(nodeup nodeup.NodeUP, key string, keyName string, flavor string, image string, rateLimit float64) *Openstack {
 
 	o := &Openstack{
 		nodeup:     nodeup,
@@ -47,6 +53,12 @@ func New(nodeup nodeup.NodeUP, key string, keyName string, flavor string, image
 
 	provider, err := openstack.AuthenticatedClient(opts)
 	o.assertError(err, "AUTH Client")
+	if limiter := pool.NewLimiter(rateLimit); limiter != nil {
+		provider.HTTPClient.Transport = &limitedTransport{
+			limiter: limiter,
+			next:    http.DefaultTransport,
+		}
+	}
 
 	o.client, err = openstack.NewIdentityV3(provider, gophercloud.EndpointOpts{
 		Region: os.Getenv("OS_REGION_NAME"),
@@ -62,21 +74,99 @@ func New(nodeup nodeup.NodeUP, key string, keyName string, flavor string, image
 }
 
 func (o *Openstack) getFlavorByName() string {
+	flavorID, err := o.FlavorID()
+	o.assertError(err, "Flavor")
+	return flavorID
+}
+
+func (o *Openstack) getImageByName() string {
+	imageID, err := o.ImageID()
+	o.assertError(err, "Error image")
+	return imageID
+}
+
+// FlavorID resolves flavor name
+func (o *Openstack) FlavorID() (string, error) {
 	o.Log().Debugf("Searching FlavorID for Flavor name: %s", o.flavorName)
 	flavorID, err := util_flavors.IDFromName(o.client, o.flavorName)
-	o.assertError(err, "Flavor")
+	if err != nil {
+		return "", err
+	}
 
 	o.Log().Debugf("Found flavor id: %s", flavorID)
-	return flavorID
+	return flavorID, nil
 }
 
-func (o *Openstack) getImageByName() string {
+// ImageID resolves image name
+func (o *Openstack) ImageID() (string, error) {
 	o.Log().Debugf("Searching ImageID for image: %s", o.imageName)
 	imageID, err := images.IDFromName(o.client, o.imageName)
-	o.assertError(err, "Error image")
+	if err != nil {
+		return "", err
+	}
 
 	o.Log().Debugf("Found image id: %s", imageID)
-	return imageID
+	return imageID, nil
+}
+
+// NetworkIDs resolves all network labels or returns error with unknown ones
+func (o *Openstack) NetworkIDs(defineNetworks string) ([]string, error) {
+	networksID, err := o.getNetworkIDs(defineNetworks)
+	if err != nil {
+		return nil, err
+	}
+
+	allPages, err := networks.List(o.client).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allNetworks, err := networks.ExtractNetworks(allPages)
+	if err != nil {
+		return nil, err
+	}
+
+	var unknown []string
+	for _, selected := range strings.Split(defineNetworks, ",") {
+		found := false
+		for _, net := range allNetworks {
+			if selected == net.Label {
+				found = true
+			}
+		}
+		if !found {
+			unknown = append(unknown, selected)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("networks not found: %s", strings.Join(unknown, ","))
+	}
+	return networksID, nil
+}
+
+// CheckKeyPair checks admin keypair exists or could be created from public key
+func (o *Openstack) CheckKeyPair() error {
+	allPages, err := keypairs.List(o.client).AllPages()
+	if err != nil {
+		return err
+	}
+	allKeyPairs, err := keypairs.ExtractKeyPairs(allPages)
+	if err != nil {
+		return err
+	}
+
+	for _, kp := range allKeyPairs {
+		if kp.Name != o.keyName {
+			continue
+		}
+		if o.key != "" && !sameKey(kp.PublicKey, o.key) {
+			return fmt.Errorf("keypair %s exists with other public key, please provide other -keyName", o.keyName)
+		}
+		return nil
+	}
+	if o.key == "" {
+		return fmt.Errorf("keypair %s not found and public key is not provided", o.keyName)
+	}
+	return nil
 }
 
 func (o *Openstack) getNetworkIDs(defineNetworks string) ([]string, error) {
@@ -112,58 +202,58 @@ func (o *Openstack) getNetworkIDs(defineNetworks string) ([]string, error) {
 	return networksID, err
 }
 
-func (o *Openstack) createAdminKey() bool {
+// EnsureKeyPair creates admin keypair from public key, created is false for existing keypair.
+// Existing keypair with other key is not replaced, it belongs to someone else
+func (o *Openstack) EnsureKeyPair() (created bool, err error) {
+	o.keyMutex.Lock()
+	defer o.keyMutex.Unlock()
 
-	//Checking existing keypair
 	allPages, err := keypairs.List(o.client).AllPages()
 	if err != nil {
-		panic(err)
+		return false, err
 	}
-
 	allKeyPairs, err := keypairs.ExtractKeyPairs(allPages)
 	if err != nil {
-		panic(err)
+		return false, err
 	}
 
-	validation := false
 	for _, kp := range allKeyPairs {
-		if kp.Name == o.keyName {
-			o.Log().Debugf("Keypair with name %s already exists", o.keyName)
-			o.Log().Debugf("Checking key data for %s", o.keyName)
-			if kp.PublicKey == string(o.key) {
-				o.Log().Debugf("Keypair with name %s already exists", o.keyName)
-				validation = true
-			} else {
-				o.Log().Debugf("Deleting keypair with name %s", o.keyName)
-				err := keypairs.Delete(o.client, o.keyName).ExtractErr()
-				if err != nil {
-					o.Log().Errorf("Can't delete keypair with name %s", o.keyName)
-				}
-			}
+		if kp.Name != o.keyName {
+			continue
 		}
-	}
-	if !validation {
-		o.Log().Infof("Keypair with name %s does not exist. Creating...", o.keyName)
-		keycreateOpts := keypairs.CreateOpts{
-			Name:      o.keyName,
-			PublicKey: o.key,
+		if !sameKey(kp.PublicKey, o.key) {
+			return false, fmt.Errorf("keypair %s exists with other public key, please provide other -keyName", o.keyName)
 		}
+		o.Log().Debugf("Keypair with name %s already exists", o.keyName)
+		return false, nil
+	}
 
-		keypair, err := keypairs.Create(o.client, keycreateOpts).Extract()
-		if err != nil {
-			o.Log().Fatalf("Keypair %s: %s", o.keyName, err)
-			return false
-		}
-		o.Log().Debugf("Keypair %s was created", keypair.Name)
+	o.Log().Infof("Keypair with name %s does not exist. Creating...", o.keyName)
+	keypair, err := keypairs.Create(o.client, keypairs.CreateOpts{
+		Name:      o.keyName,
+		PublicKey: o.key,
+	}).Extract()
+	if err != nil {
+		return false, fmt.Errorf("keypair %s: %s", o.keyName, err)
 	}
+	o.Log().Debugf("Keypair %s was created", keypair.Name)
+	return true, nil
+}
 
-	return true
+// DeleteKeyPair removes admin keypair
+func (o *Openstack) DeleteKeyPair(name string) error {
+	err := keypairs.Delete(o.client, name).ExtractErr()
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		return nil
+	}
+	return err
 }
 
-func (o *Openstack) CreateServer(hostname string, timeout int, group string, networks string, availabilityZone string) (*servers.Server, error) {
+func (o *Openstack) CreateServer(hostname string, timeout int, group string, networks string, availabilityZone string, userData []byte) (*servers.Server, error) {
 
+	// Names are checked before bootstrap, server may be created by another run since
 	if o.isServerExist(hostname) {
-		o.Log().Fatalf("Server %s already exists", hostname)
+		return nil, fmt.Errorf("server %s already exists", hostname)
 	}
 
 	flavorID := o.getFlavorByName()
@@ -176,8 +266,6 @@ func (o *Openstack) CreateServer(hostname string, timeout int, group string, net
 
 	o.Log().Infof("Creating server with hostname %s", hostname)
 
-	o.createAdminKey()
-
 	var s []servers.Network
 
 	for _, n := range networksIDs {
@@ -192,6 +280,7 @@ func (o *Openstack) CreateServer(hostname string, timeout int, group string, net
 		ImageRef:    imageID,
 		Networks:    s,
 		ConfigDrive: &configDrive,
+		UserData:    userData,
 	}
 
 	// TODO: add auto balancer
@@ -226,19 +315,34 @@ func (o *Openstack) CreateServer(hostname string, timeout int, group string, net
 		}
 	}
 
-	info, err := o.GetServer(server.ID)
+	return server, nil
+}
+
+// WaitServerActive polls server status until ACTIVE or ctx is cancelled
+func (o *Openstack) WaitServerActive(ctx context.Context, id string, timeout int) (*servers.Server, error) {
+	info, err := o.GetServer(id)
 	if err != nil {
-		o.Log().Error(err)
+		return nil, err
 	}
 
 	o.Log().Debugf("Waiting server %s up", info.Name)
 	i := 0
 	status := ""
 	for {
-		time.Sleep(time.Duration(timeout)*time.Second) //Waiting before retry getting openstack host status
-		info, err = o.GetServer(server.ID)
+		//Waiting before retry getting openstack host status
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(timeout) * time.Second):
+		}
+		info, err = o.GetServer(id)
 		if err != nil {
 			o.Log().Error(err)
+			i++
+			if i >= 10 {
+				return nil, err
+			}
+			continue
 		}
 
 		if info.Status == status {
@@ -255,7 +359,6 @@ func (o *Openstack) CreateServer(hostname string, timeout int, group string, net
 			o.Log().Errorf("Status: %s", info.Status)
 			o.Log().Errorf("Fault message: %s", info.Fault.Message)
 			o.Log().Errorf("Fault code: %d", info.Fault.Code)
-			o.DeleteServer(server.ID)
 			return info, errors.New(info.Fault.Message)
 		}
 		o.Log().Debugf("Server %s status is %s", info.Name, info.Status)
@@ -278,6 +381,13 @@ func (o *Openstack) GetServer(sid string) (*servers.Server, error) {
 	return server, nil
 }
 
+// ConsoleOutput returns last lines of server console log
+func (o *Openstack) ConsoleOutput(sid string, lines int) (string, error) {
+	return servers.ShowConsoleOutput(o.client, sid, servers.ShowConsoleOutputOpts{
+		Length: lines,
+	}).Extract()
+}
+
 func (o *Openstack) GetServerDetail(sid string) (Server, error) {
 	var server Server
 	err := servers.Get(o.client, sid).ExtractInto(&server)
@@ -425,17 +535,13 @@ func (o *Openstack) DeleteServer(sid string) error {
 	return result.Err
 }
 
-func (o *Openstack) DeleteIfError(id string, err error) bool {
-	if err != nil {
-		o.Log().Error(err)
-		err = o.DeleteServer(id)
-		if err != nil {
-			o.Log().Errorf("Openstack host delete error %s", err)
-		}
-		return true
-	} else {
-		return false
+// RemoveServer deletes server, already deleted server is not an error
+func (o *Openstack) RemoveServer(sid string) error {
+	err := o.DeleteServer(sid)
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		return nil
 	}
+	return err
 }
 
 func (o *Openstack) IDFromName(hostname string) (string, error) {
@@ -490,9 +596,8 @@ func (o *Openstack) Migrate(serverID string, hypervisorName string, blockMigrati
 	return err
 }
 
-func (o *Openstack) MigrateHost(id string, hypervisor string, wg *sync.WaitGroup) bool {
-	defer wg.Done()
-
+// MigrateHost waits migration up to an hour, live migration itself is not cancelled with ctx
+func (o *Openstack) MigrateHost(ctx context.Context, id string, hypervisor string) bool {
 	serverInfo, err := o.GetServer(id)
 	if err != nil {
 		o.Log().Error(err)
@@ -544,6 +649,10 @@ func (o *Openstack) MigrateHost(id string, hypervisor string, wg *sync.WaitGroup
 	case <-timer.C:
 		doneCh <- true
 		return false
+	case <-ctx.Done():
+		o.Log().Warnf("Stopped waiting migration of server %s: %s", id, ctx.Err())
+		doneCh <- true
+		return false
 	case res := <-resChan:
 		return res
 
@@ -1,15 +1,52 @@
 package openstack
 
 import (
+	"context"
 	"github.com/gophercloud/gophercloud"
 	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
 	"github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
 
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/hypervisors"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/onetwotrip/nodeup/pkg/pool"
+	"net/http"
+	"sync"
 	"time"
 )
 
+// Cloud is Openstack API used by nodeup, tests replace it with fake
+type Cloud interface {
+	FlavorID() (string, error)
+	ImageID() (string, error)
+	NetworkIDs(defineNetworks string) ([]string, error)
+	CheckKeyPair() error
+	EnsureKeyPair() (created bool, err error)
+	DeleteKeyPair(name string) error
+
+	CreateServer(hostname string, timeout int, group string, networks string, availabilityZone string, userData []byte) (*servers.Server, error)
+	WaitServerActive(ctx context.Context, id string, timeout int) (*servers.Server, error)
+	GetServer(sid string) (*servers.Server, error)
+	GetServerDetail(sid string) (Server, error)
+	GetServers() ([]servers.Server, error)
+	ConsoleOutput(sid string, lines int) (string, error)
+	IDFromName(hostname string) (string, error)
+	StartServer(id string) error
+	StopServer(id string) error
+	DeleteServer(sid string) error
+	RemoveServer(sid string) error
+	MigrateHost(ctx context.Context, id string, hypervisor string) bool
+
+	GetFlavors() ([]flavors.Flavor, error)
+	GetFlavorInfo(id string) (*flavors.Flavor, error)
+	GetHypervisors() ([]hypervisors.Hypervisor, error)
+	GetHypervisorInfo(id string) (*hypervisors.Hypervisor, error)
+	GetHypervisorStatistics(id int) (*hypervisors.Statistics, error)
+	HypervisorScheduler(criteria string) []hypervisors.Hypervisor
+	GetHypervisorWithSensitiveCriteria(criteria string) hypervisors.Hypervisor
+}
+
 type Openstack struct {
 	nodeup     nodeup.NodeUP
 	client     *gophercloud.ServiceClient
@@ -17,11 +54,18 @@ type Openstack struct {
 	imageName  string
 	key        string
 	keyName    string
+	keyMutex   sync.Mutex
 	cache      *cache.Cache
 
 	log *logrus.Entry
 }
 
+// limitedTransport waits limiter before every API request
+type limitedTransport struct {
+	limiter *pool.Limiter
+	next    http.RoundTripper
+}
+
 type Server struct {
 	// ID uniquely identifies this server amongst all other servers,
 	// including those not accessible to the current tenant.
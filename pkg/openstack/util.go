@@ -1,6 +1,10 @@
 package openstack
 
-import "github.com/sirupsen/logrus"
+import (
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
 
 func (o *Openstack) Log() *logrus.Entry {
 	log := o.nodeup.Log().WithField("context", "openstack")
@@ -13,6 +17,21 @@ func (o *Openstack) assertError(err error, message string) {
 	}
 }
 
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.limiter.Wait()
+	return t.next.RoundTrip(req)
+}
+
+// sameKey compares type and key data of authorized_keys lines, comments are ignored
+func sameKey(a string, b string) bool {
+	fieldsA := strings.Fields(a)
+	fieldsB := strings.Fields(b)
+	if len(fieldsA) < 2 || len(fieldsB) < 2 {
+		return strings.TrimSpace(a) == strings.TrimSpace(b)
+	}
+	return fieldsA[0] == fieldsB[0] && fieldsA[1] == fieldsB[1]
+}
+
 func (c sortedHypervisorsByvCPU) Len() int           { return len(c) }
 func (c sortedHypervisorsByvCPU) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
 func (c sortedHypervisorsByvCPU) Less(i, j int) bool { return c[i].VCPUsUsed > c[j].VCPUsUsed }
@@ -0,0 +1,14 @@
+package openstack
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSameKey(t *testing.T) {
+	assert.True(t, sameKey("ssh-rsa AAAAB3 fox@host\n", "ssh-rsa AAAAB3 Generated-by-Nova"))
+	assert.True(t, sameKey("ssh-rsa AAAAB3", "ssh-rsa AAAAB3\n"))
+	assert.False(t, sameKey("ssh-rsa AAAAB3 fox@host", "ssh-rsa AAAAC4 fox@host"))
+	assert.False(t, sameKey("ssh-ed25519 AAAAB3", "ssh-rsa AAAAB3"))
+	assert.False(t, sameKey("", "ssh-rsa AAAAB3"))
+}
@@ -0,0 +1,109 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"text/tabwriter"
+)
+
+func New(mode string) *Plan {
+	return &Plan{
+		Mode: mode,
+	}
+}
+
+// Print writes plan as text or json
+func (p *Plan) Print(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case "text", "":
+		return p.printText(w)
+	}
+	return fmt.Errorf("unknown plan format %s", format)
+}
+
+func (p *Plan) printText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Plan: %s\n", p.Mode)
+
+	if len(p.Create) > 0 {
+		fmt.Fprintf(tw, "\nCreate %d server(s):\n", len(p.Create))
+		for _, c := range p.Create {
+			fmt.Fprintf(tw, "  + %s\tflavor=%s (%s)\timage=%s (%s)\tnetworks=%s (%s)\n",
+				c.Hostname, c.Flavor, c.FlavorID, c.Image, c.ImageID, strings.Join(c.Networks, ","), strings.Join(c.NetworkIDs, ","))
+			if c.AvailabilityZone != "" || c.Group != "" {
+				fmt.Fprintf(tw, "    \tzone=%s\tgroup=%s\n", c.AvailabilityZone, c.Group)
+			}
+			if c.PolicyName != "" {
+				fmt.Fprintf(tw, "    \t%s\tpolicy=%s\tgroup=%s\n", c.Provisioner, c.PolicyName, c.PolicyGroup)
+			} else {
+				fmt.Fprintf(tw, "    \t%s\trun_list=%s\tenvironment=%s\n", c.Provisioner, strings.Join(c.RunList, ","), c.Environment)
+			}
+		}
+	}
+
+	if len(p.Delete) > 0 {
+		fmt.Fprintf(tw, "\nDelete %d server(s):\n", len(p.Delete))
+		for _, d := range p.Delete {
+			if d.Error != "" {
+				fmt.Fprintf(tw, "  - %s\terror: %s\n", d.Hostname, d.Error)
+				continue
+			}
+			fmt.Fprintf(tw, "  - %s\tserver=%s\tchef node=%t\tchef client=%t\n", d.Hostname, d.ServerID, d.ChefNode, d.ChefClient)
+			if d.Reason != "" {
+				fmt.Fprintf(tw, "    \treason: %s\n", d.Reason)
+			}
+		}
+	}
+
+	if len(p.Migrate) > 0 {
+		fmt.Fprintf(tw, "\nMigrate %d server(s):\n", len(p.Migrate))
+		for _, m := range p.Migrate {
+			fmt.Fprintf(tw, "  ~ %s\t(%s)\t%s -> %s\n", m.Server, m.ServerID, m.From, m.To)
+		}
+	}
+
+	if len(p.Orphans) > 0 {
+		fmt.Fprintf(tw, "\nChef nodes without server %d, not removed:\n", len(p.Orphans))
+		for _, name := range p.Orphans {
+			fmt.Fprintf(tw, "  ! %s\tremove with -deleteNodes %s\n", name, name)
+		}
+	}
+
+	if len(p.Create) == 0 && len(p.Delete) == 0 && len(p.Migrate) == 0 {
+		fmt.Fprintln(tw, "\nNothing to do")
+	}
+	return tw.Flush()
+}
+
+// Save writes rebalance plan to file
+func (r *Rebalance) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// LoadRebalance reads rebalance plan from file
+func LoadRebalance(path string) (*Rebalance, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Rebalance{}
+	err = json.Unmarshal(data, r)
+	if err != nil {
+		return nil, fmt.Errorf("plan %s: %s", path, err)
+	}
+	return r, nil
+}
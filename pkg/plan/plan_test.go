@@ -0,0 +1,43 @@
+package plan
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPrintText(t *testing.T) {
+	p := New("rebalance")
+	p.Migrate = append(p.Migrate, Move{"search-1", "id-1", "hv1", "hv2"})
+
+	var buf bytes.Buffer
+	assert.Equal(t, nil, p.Print(&buf, "text"))
+	testData := `Plan: rebalance
+
+Migrate 1 server(s):
+  ~ search-1  (id-1)  hv1 -> hv2
+`
+	assert.Equal(t, testData, buf.String())
+}
+
+func TestPrintJson(t *testing.T) {
+	p := New("delete")
+	p.Delete = append(p.Delete, Delete{Hostname: "search-1", ServerID: "id-1", ChefNode: true})
+
+	var buf bytes.Buffer
+	assert.Equal(t, nil, p.Print(&buf, "json"))
+	testData := `{
+  "mode": "delete",
+  "delete": [
+    {
+      "hostname": "search-1",
+      "server_id": "id-1",
+      "chef_node": true,
+      "chef_client": false
+    }
+  ]
+}
+`
+	assert.Equal(t, testData, buf.String())
+	assert.NotEqual(t, nil, p.Print(&buf, "yaml"))
+}
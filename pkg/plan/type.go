@@ -0,0 +1,63 @@
+package plan
+
+import "time"
+
+// Plan is resolved list of changes without any mutation in Openstack or Chef
+type Plan struct {
+	Mode    string   `json:"mode"`
+	Create  []Create `json:"create,omitempty"`
+	Delete  []Delete `json:"delete,omitempty"`
+	Migrate []Move   `json:"migrate,omitempty"`
+	// Orphans are chef nodes without server, -ensure reports them only
+	Orphans []string `json:"orphans,omitempty"`
+}
+
+type Create struct {
+	Hostname         string   `json:"hostname"`
+	Flavor           string   `json:"flavor"`
+	FlavorID         string   `json:"flavor_id"`
+	Image            string   `json:"image"`
+	ImageID          string   `json:"image_id"`
+	Networks         []string `json:"networks"`
+	NetworkIDs       []string `json:"network_ids"`
+	AvailabilityZone string   `json:"availability_zone,omitempty"`
+	Group            string   `json:"group,omitempty"`
+	Provisioner      string   `json:"provisioner"`
+	RunList          []string `json:"run_list,omitempty"`
+	Environment      string   `json:"environment,omitempty"`
+	PolicyName       string   `json:"policy_name,omitempty"`
+	PolicyGroup      string   `json:"policy_group,omitempty"`
+}
+
+type Delete struct {
+	Hostname   string `json:"hostname"`
+	ServerID   string `json:"server_id,omitempty"`
+	ChefNode   bool   `json:"chef_node"`
+	ChefClient bool   `json:"chef_client"`
+	Error      string `json:"error,omitempty"`
+	// Reason why -ensure removes host
+	Reason string `json:"reason,omitempty"`
+}
+
+type Move struct {
+	Server   string `json:"server"`
+	ServerID string `json:"server_id"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+}
+
+// Rebalance is saved migration plan with statistics it was calculated from
+type Rebalance struct {
+	Created     time.Time    `json:"created"`
+	Hosts       string       `json:"hosts"`
+	VMCount     int          `json:"vm_count"`
+	PerHost     int          `json:"per_hypervisor"`
+	Hypervisors []Hypervisor `json:"hypervisors"`
+	Migrate     []Move       `json:"migrate"`
+}
+
+type Hypervisor struct {
+	Name    string   `json:"name"`
+	Count   int      `json:"count"`
+	Servers []string `json:"servers"`
+}
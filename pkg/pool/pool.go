@@ -0,0 +1,91 @@
+package pool
+
+import (
+	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+	"time"
+)
+
+func New(nodeup nodeup.NodeUP, config Config, stop <-chan struct{}) *Pool {
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	return &Pool{
+		nodeup: nodeup,
+		config: config,
+		stop:   stop,
+		slots:  make(chan struct{}, config.Concurrency),
+	}
+}
+
+// Go waits for free slot and runs task, false means pool is stopped and task is skipped.
+// Tasks are started from one goroutine
+func (p *Pool) Go(task func()) bool {
+	select {
+	case p.slots <- struct{}{}:
+	case <-p.stop:
+		return false
+	}
+
+	if p.config.Stagger > 0 && !p.last.IsZero() {
+		select {
+		case <-time.After(p.config.Stagger - time.Since(p.last)):
+		case <-p.stop:
+		}
+	}
+	if p.stopped() {
+		<-p.slots
+		return false
+	}
+	p.last = time.Now()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() { <-p.slots }()
+		task()
+	}()
+	return true
+}
+
+// Wait blocks until started tasks are finished
+func (p *Pool) Wait() {
+	p.Log().Debug("Waiting for workers to finish")
+	p.wg.Wait()
+}
+
+func (p *Pool) stopped() bool {
+	select {
+	case <-p.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewLimiter allows perSecond requests, zero is unlimited
+func NewLimiter(perSecond float64) *Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &Limiter{
+		interval: time.Duration(float64(time.Second) / perSecond),
+	}
+}
+
+// Wait blocks until next request is allowed
+func (l *Limiter) Wait() {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mutex.Unlock()
+
+	time.Sleep(wait)
+}
@@ -0,0 +1,79 @@
+package pool
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testNodeUP struct{}
+
+func (testNodeUP) Version() string    { return "test" }
+func (testNodeUP) Log() *logrus.Entry { return logrus.NewEntry(logrus.New()) }
+
+func TestConcurrency(t *testing.T) {
+	p := New(testNodeUP{}, Config{Concurrency: 2}, nil)
+
+	var mutex sync.Mutex
+	running, max, done := 0, 0, 0
+	for i := 0; i < 6; i++ {
+		assert.True(t, p.Go(func() {
+			mutex.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			mutex.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mutex.Lock()
+			running--
+			done++
+			mutex.Unlock()
+		}))
+	}
+	p.Wait()
+	assert.Equal(t, 2, max)
+	assert.Equal(t, 6, done)
+}
+
+func TestStagger(t *testing.T) {
+	p := New(testNodeUP{}, Config{Concurrency: 5, Stagger: 20 * time.Millisecond}, nil)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		p.Go(func() {})
+	}
+	p.Wait()
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+}
+
+func TestStop(t *testing.T) {
+	stop := make(chan struct{})
+	p := New(testNodeUP{}, Config{Concurrency: 1}, stop)
+
+	release := make(chan struct{})
+	assert.True(t, p.Go(func() { <-release }))
+
+	close(stop)
+	// Pool is full, so next task waits for slot or stop
+	assert.False(t, p.Go(func() { t.Error("task is started after stop") }))
+	close(release)
+	p.Wait()
+}
+
+func TestLimiter(t *testing.T) {
+	var l *Limiter
+	l.Wait()
+	assert.Equal(t, (*Limiter)(nil), NewLimiter(0))
+
+	l = NewLimiter(100)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		l.Wait()
+	}
+	assert.True(t, time.Since(start) >= 30*time.Millisecond)
+}
@@ -0,0 +1,36 @@
+package pool
+
+import (
+	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// Config of worker pool
+type Config struct {
+	// Concurrency is max tasks in flight
+	Concurrency int
+	// Stagger is min delay between task starts
+	Stagger time.Duration
+}
+
+// Pool runs tasks with bounded concurrency until stop is closed
+type Pool struct {
+	nodeup nodeup.NodeUP
+	config Config
+	stop   <-chan struct{}
+
+	slots chan struct{}
+	last  time.Time
+	wg    sync.WaitGroup
+
+	log *logrus.Entry
+}
+
+// Limiter spaces out API requests, nil Limiter doesn't limit
+type Limiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
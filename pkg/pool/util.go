@@ -0,0 +1,10 @@
+package pool
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+func (p *Pool) Log() *logrus.Entry {
+	log := p.nodeup.Log().WithField("context", "pool")
+	return log
+}
@@ -0,0 +1,70 @@
+package provisioner
+
+import (
+	"context"
+	"github.com/onetwotrip/nodeup/pkg/chef"
+	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+	"github.com/onetwotrip/nodeup/pkg/ssh"
+	"os"
+)
+
+var _ Provisioner = &Ansible{}
+
+// NewAnsible runs ansible-pull with playbook from repository on the host itself
+func NewAnsible(nodeup nodeup.NodeUP, config Config, repo string, branch string, playbook string) *Ansible {
+	return &Ansible{
+		nodeup:   nodeup,
+		config:   config,
+		repo:     repo,
+		branch:   branch,
+		playbook: playbook,
+	}
+}
+
+func (a *Ansible) Prepare() error {
+	hosts, err := chef.CreateHostFile(a.config.Hostname, a.config.Domain)
+	if err != nil {
+		return err
+	}
+
+	a.files = map[string][]byte{
+		"hosts": hosts,
+	}
+	return nil
+}
+
+func (a *Ansible) Upload(s *ssh.Ssh) error {
+	return upload(s, a.files, a.config.UploadDir)
+}
+
+func (a *Ansible) Install(ctx context.Context, s *ssh.Ssh, out *os.File) error {
+	return run(ctx, s, a.installCommands(), out)
+}
+
+func (a *Ansible) Run(ctx context.Context, s *ssh.Ssh, out *os.File) error {
+	return run(ctx, s, a.runCommands(), out)
+}
+
+func (a *Ansible) Cleanup(ctx context.Context, s *ssh.Ssh, out *os.File) error {
+	return nil
+}
+
+func (a *Ansible) Files() map[string][]byte {
+	return a.files
+}
+
+func (a *Ansible) Commands() []string {
+	return commandLines(append(a.installCommands(), a.runCommands()...))
+}
+
+func (a *Ansible) installCommands() []ssh.Command {
+	return append(prepareCommands(a.config),
+		a.config.retryCommand("ansible-install", "sudo apt-get update && sudo apt-get -y install ansible git"),
+	)
+}
+
+func (a *Ansible) runCommands() []ssh.Command {
+	return []ssh.Command{
+		a.config.command("ansible-pull", "sudo ansible-pull -U "+a.repo+" -C "+a.branch+" -i localhost, -e hostname="+a.config.Hostname+" -e domain="+a.config.Domain+" "+a.playbook),
+	}
+}
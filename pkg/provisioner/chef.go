@@ -0,0 +1,133 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"github.com/onetwotrip/nodeup/pkg/chef"
+	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+	"github.com/onetwotrip/nodeup/pkg/ssh"
+	"os"
+)
+
+var _ Provisioner = &Chef{}
+
+func NewChef(nodeup nodeup.NodeUP, config Config, chefConfig ChefConfig) *Chef {
+	return &Chef{
+		nodeup: nodeup,
+		config: config,
+		chef:   chefConfig,
+	}
+}
+
+func (c *Chef) Prepare() error {
+	if c.chef.Validatorless {
+		return c.prepareValidatorless()
+	}
+
+	chefData, err := chef.New(c.nodeup, c.config.Hostname, c.config.Domain, c.chef.ServerUrl, c.chef.ValidationPem, c.config.UploadDir+"/validation.pem", c.chef.Bootstrap)
+	if err != nil {
+		return err
+	}
+
+	c.files = map[string][]byte{
+		"bootstrap.json": chefData.BootstrapJson,
+		"validation.pem": chefData.ValidationPem,
+		"client.rb":      chefData.ChefConfig,
+		"hosts":          chefData.Hosts,
+	}
+	return nil
+}
+
+// Same as knife validatorless bootstrap: client, key and node are created via API
+func (c *Chef) prepareValidatorless() error {
+	if c.chef.Client == nil {
+		return errors.New("validatorless bootstrap needs chef API client")
+	}
+
+	chefData, err := chef.New(c.nodeup, c.config.Hostname, c.config.Domain, c.chef.ServerUrl, nil, "", c.chef.Bootstrap)
+	if err != nil {
+		return err
+	}
+
+	clientPem, err := c.chef.Client.ValidatorlessBootstrap(c.config.Hostname, c.chef.Environment, c.chef.Bootstrap)
+	if err != nil {
+		return err
+	}
+
+	c.files = map[string][]byte{
+		"bootstrap.json": chefData.BootstrapJson,
+		"client.pem":     []byte(clientPem),
+		"client.rb":      chefData.ChefConfig,
+		"hosts":          chefData.Hosts,
+	}
+	return nil
+}
+
+func (c *Chef) Upload(s *ssh.Ssh) error {
+	return upload(s, c.files, c.config.UploadDir)
+}
+
+func (c *Chef) Install(ctx context.Context, s *ssh.Ssh, out *os.File) error {
+	return run(ctx, s, c.installCommands(), out)
+}
+
+func (c *Chef) Run(ctx context.Context, s *ssh.Ssh, out *os.File) error {
+	return run(ctx, s, c.runCommands(), out)
+}
+
+func (c *Chef) Cleanup(ctx context.Context, s *ssh.Ssh, out *os.File) error {
+	return run(ctx, s, c.cleanupCommands(), out)
+}
+
+func (c *Chef) Files() map[string][]byte {
+	return c.files
+}
+
+func (c *Chef) Commands() []string {
+	commands := append(c.installCommands(), c.runCommands()...)
+	return commandLines(append(commands, c.cleanupCommands()...))
+}
+
+func (c *Chef) installCommands() []ssh.Command {
+	dir := c.config.UploadDir
+
+	key := "sudo chmod 0600 " + dir + "/validation.pem"
+	if c.chef.Validatorless {
+		key = "sudo mv " + dir + "/client.pem /etc/chef/client.pem && sudo chmod 0600 /etc/chef/client.pem"
+	}
+
+	return append(prepareCommands(c.config),
+		c.config.command("chef-dir", "sudo mkdir -p /etc/chef"),
+		c.config.retryCommand("chef-install", "wget -q https://omnitruck.chef.io/install.sh && sudo bash ./install.sh -v "+c.chef.Version+" && rm install.sh"),
+		c.config.command("chef-key", key),
+	)
+}
+
+func (c *Chef) runCommands() []ssh.Command {
+	dir := c.config.UploadDir
+
+	// Policyfile nodes have no environment
+	chefClient := "sudo chef-client -c " + dir + "/client.rb -j " + dir + "/bootstrap.json"
+	if c.chef.Environment != "" {
+		chefClient = "sudo chef-client -c " + dir + "/client.rb -E " + c.chef.Environment + " -j " + dir + "/bootstrap.json"
+	}
+
+	return []ssh.Command{
+		c.config.command("chef-client", chefClient),
+	}
+}
+
+func (c *Chef) cleanupCommands() []ssh.Command {
+	dir := c.config.UploadDir
+
+	remove := "sudo rm " + dir + "/client.rb && sudo rm " + dir + "/validation.pem && rm " + dir + "/bootstrap.json"
+	if c.chef.Validatorless {
+		remove = "sudo rm " + dir + "/client.rb && rm " + dir + "/bootstrap.json"
+	}
+
+	return []ssh.Command{
+		c.config.command("cleanup", remove),
+		// Converge again with node own client key
+		c.config.command("chef-client-rerun", "sudo chef-client"),
+	}
+}
@@ -0,0 +1,68 @@
+package provisioner
+
+import (
+	"context"
+	"github.com/onetwotrip/nodeup/pkg/chef"
+	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+	"github.com/onetwotrip/nodeup/pkg/ssh"
+	"os"
+)
+
+var _ Provisioner = &Shell{}
+
+// NewShell runs script as root with NODEUP_HOSTNAME and NODEUP_DOMAIN variables
+func NewShell(nodeup nodeup.NodeUP, config Config, script []byte) *Shell {
+	return &Shell{
+		nodeup: nodeup,
+		config: config,
+		script: script,
+	}
+}
+
+func (s *Shell) Prepare() error {
+	hosts, err := chef.CreateHostFile(s.config.Hostname, s.config.Domain)
+	if err != nil {
+		return err
+	}
+
+	s.files = map[string][]byte{
+		"bootstrap.sh": s.script,
+		"hosts":        hosts,
+	}
+	return nil
+}
+
+func (s *Shell) Upload(client *ssh.Ssh) error {
+	return upload(client, s.files, s.config.UploadDir)
+}
+
+func (s *Shell) Install(ctx context.Context, client *ssh.Ssh, out *os.File) error {
+	return run(ctx, client, prepareCommands(s.config), out)
+}
+
+func (s *Shell) Run(ctx context.Context, client *ssh.Ssh, out *os.File) error {
+	return run(ctx, client, s.runCommands(), out)
+}
+
+func (s *Shell) Cleanup(ctx context.Context, client *ssh.Ssh, out *os.File) error {
+	return run(ctx, client, s.cleanupCommands(), out)
+}
+
+func (s *Shell) Files() map[string][]byte {
+	return s.files
+}
+
+func (s *Shell) Commands() []string {
+	commands := append(prepareCommands(s.config), s.runCommands()...)
+	return commandLines(append(commands, s.cleanupCommands()...))
+}
+
+func (s *Shell) runCommands() []ssh.Command {
+	return []ssh.Command{
+		s.config.command("script", "sudo NODEUP_HOSTNAME="+s.config.Hostname+" NODEUP_DOMAIN="+s.config.Domain+" bash "+s.config.UploadDir+"/bootstrap.sh"),
+	}
+}
+
+func (s *Shell) cleanupCommands() []ssh.Command {
+	return []ssh.Command{s.config.command("cleanup", "rm "+s.config.UploadDir+"/bootstrap.sh")}
+}
@@ -0,0 +1,88 @@
+package provisioner
+
+import (
+	"context"
+	"github.com/onetwotrip/nodeup/pkg/chef"
+	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+	"github.com/onetwotrip/nodeup/pkg/ssh"
+	"github.com/sirupsen/logrus"
+	"os"
+	"time"
+)
+
+// Provisioner configures created server over SSH or cloud-init
+type Provisioner interface {
+	// Prepare renders files for host
+	Prepare() error
+	// Upload transfers files to host
+	Upload(s *ssh.Ssh) error
+	// Install sets hostname and installs packages and provisioner tools
+	Install(ctx context.Context, s *ssh.Ssh, out *os.File) error
+	// Run configures host
+	Run(ctx context.Context, s *ssh.Ssh, out *os.File) error
+	// Cleanup removes bootstrap files from host
+	Cleanup(ctx context.Context, s *ssh.Ssh, out *os.File) error
+
+	// Files returns prepared files for cloud-init
+	Files() map[string][]byte
+	// Commands returns Install, Run and Cleanup commands for cloud-init
+	Commands() []string
+}
+
+// Config is common for all provisioners
+type Config struct {
+	Hostname  string
+	Domain    string
+	UploadDir string
+	// Packages is comma separated list installed before provisioning
+	Packages string
+
+	// CommandTimeout is limit for one step, zero is no limit
+	CommandTimeout time.Duration
+	// Retries for steps which are safe to repeat
+	Retries int
+}
+
+type ChefConfig struct {
+	ServerUrl     string
+	ValidationPem []byte
+	Version       string
+	Environment   string
+	Bootstrap     *chef.Bootstrap
+
+	// Validatorless creates node client and key with Client instead of uploading validation.pem
+	Validatorless bool
+	Client        *chef.ChefClient
+}
+
+type Chef struct {
+	nodeup nodeup.NodeUP
+	config Config
+	chef   ChefConfig
+
+	files map[string][]byte
+
+	log *logrus.Entry
+}
+
+type Shell struct {
+	nodeup nodeup.NodeUP
+	config Config
+
+	script []byte
+	files  map[string][]byte
+
+	log *logrus.Entry
+}
+
+type Ansible struct {
+	nodeup nodeup.NodeUP
+	config Config
+
+	repo     string
+	branch   string
+	playbook string
+	files    map[string][]byte
+
+	log *logrus.Entry
+}
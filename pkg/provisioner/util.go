@@ -0,0 +1,97 @@
+package provisioner
+
+import (
+	"context"
+	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+	"github.com/onetwotrip/nodeup/pkg/ssh"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"time"
+)
+
+func logger(nodeup nodeup.NodeUP, name string) *logrus.Entry {
+	return nodeup.Log().WithField("context", "provisioner").WithField("provisioner", name)
+}
+
+func upload(s *ssh.Ssh, files map[string][]byte, dir string) error {
+	var list []ssh.File
+	for name, data := range files {
+		list = append(list, ssh.File{Name: name, Data: data, Mode: fileMode(name)})
+	}
+	return s.Upload(dir, list...)
+}
+
+// Keys are readable by login user only
+func fileMode(name string) os.FileMode {
+	if strings.HasSuffix(name, ".pem") {
+		return 0600
+	}
+	return 0644
+}
+
+func run(ctx context.Context, s *ssh.Ssh, commands []ssh.Command, out *os.File) error {
+	for _, command := range commands {
+		if out != nil {
+			command.Output = out
+		}
+		result, err := s.Run(ctx, command)
+		if err != nil {
+			return err
+		}
+		s.Log().Infof("Step %s finished in %s", result.Name, result.Duration.Round(time.Second))
+	}
+	return nil
+}
+
+// commandLines returns commands for cloud-init script
+func commandLines(commands []ssh.Command) []string {
+	var lines []string
+	for _, command := range commands {
+		lines = append(lines, command.Cmd)
+	}
+	return lines
+}
+
+// command is a step without retries
+func (c Config) command(name string, cmd string) ssh.Command {
+	return ssh.Command{
+		Name:    name,
+		Cmd:     cmd,
+		Timeout: c.CommandTimeout,
+	}
+}
+
+// retryCommand is a step which is safe to repeat like package install
+func (c Config) retryCommand(name string, cmd string) ssh.Command {
+	command := c.command(name, cmd)
+	command.Retries = c.Retries
+	command.RetryDelay = 10 * time.Second
+	return command
+}
+
+// Commands setting hostname and installing packages before provisioning
+func prepareCommands(config Config) []ssh.Command {
+	data := []ssh.Command{
+		config.command("hostname", "sudo mv "+config.UploadDir+"/hosts /etc/hosts && sudo hostname -F /etc/hostname"),
+	}
+
+	packages := strings.Replace(config.Packages, " ", "", -1)
+	packages = strings.Replace(packages, ",", " ", -1)
+	if len(packages) != 0 {
+		data = append(data, config.retryCommand("packages", "sudo apt-get -y install "+packages))
+	}
+	return data
+}
+
+func (c *Chef) Log() *logrus.Entry {
+	return logger(c.nodeup, "chef")
+}
+
+func (s *Shell) Log() *logrus.Entry {
+	return logger(s.nodeup, "shell")
+}
+
+func (a *Ansible) Log() *logrus.Entry {
+	return logger(a.nodeup, "ansible")
+}
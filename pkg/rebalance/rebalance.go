@@ -1,18 +1,19 @@
 package rebalance
 
 import (
+	"fmt"
 	"github.com/onetwotrip/nodeup/pkg/nodeup"
 	"github.com/onetwotrip/nodeup/pkg/openstack"
+	"github.com/onetwotrip/nodeup/pkg/plan"
 	"math"
 	"os"
-	"os/signal"
 	"sort"
 	"strings"
 	"sync"
-	"syscall"
+	"time"
 )
 
-func New(nodeup nodeup.NodeUP) *Rebalance {
+func New(nodeup *nodeup.NodeUP) *Rebalance {
 	r := &Rebalance{
 		nodeup: nodeup,
 	}
@@ -23,46 +24,189 @@ func (r *Rebalance) Init() {
 
 	r.nodeup.Exitcode = 0
 
-	// handle sigterm correctly
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		s := <-c
-		logger := r.Log().WithField("signal", s.String())
-		logger.Debug("received signal")
-		r.nodeup.Stop()
-	}()
-
 	r.Log().Infof("NodeUP %s starting", r.nodeup.Ver)
 	r.Log().Info("Rebalance mode enabled")
+
+	if r.nodeup.ApplyPlan != "" {
+		r.applyPlan(r.nodeup.ApplyPlan)
+		return
+	}
+
 	r.Log().Info("Processing server list")
 
-	allServers, err := r.nodeup.Openstack.GetServers()
+	servers, err := r.servers(r.nodeup.Hosts)
 	if err != nil {
 		r.Log().Fatal(err)
 	}
+	if len(servers) == 0 {
+		os.Exit(0)
+	}
+	status, vmByHypervisor := r.calculateUsage(servers)
+	migrationPlan := r.findMigration(status, vmByHypervisor)
+	if r.nodeup.DryRun {
+		r.nodeup.PrintPlan(r.plan(migrationPlan, servers))
+		os.Exit(0)
+	}
+	if r.nodeup.PlanOut != "" {
+		r.savePlan(r.nodeup.PlanOut, status, r.plan(migrationPlan, servers))
+		os.Exit(0)
+	}
+	r.rebalance(r.plan(migrationPlan, servers).Migrate)
+}
 
-	var ids []string
-	var servers []openstack.Server
+// servers returns details of servers with hosts in name
+func (r *Rebalance) servers(hosts string) ([]openstack.Server, error) {
+	allServers, err := r.nodeup.Openstack.GetServers()
+	if err != nil {
+		return nil, err
+	}
 
+	var servers []openstack.Server
 	for _, host := range allServers {
-		if strings.Contains(host.Name, r.nodeup.Hosts) {
-			ids = append(ids, host.ID)
+		if !strings.Contains(host.Name, hosts) {
+			continue
 		}
-	}
-
-	for _, id := range ids {
-		server, err := r.nodeup.Openstack.GetServerDetail(id)
+		server, err := r.nodeup.Openstack.GetServerDetail(host.ID)
 		if err != nil {
-			r.Log().Fatal(err)
+			return nil, err
 		}
 		servers = append(servers, server)
 	}
-	if len(servers) == 0 {
+	return servers, nil
+}
+
+func (r *Rebalance) savePlan(path string, status *hypervisorsStatistics, p *plan.Plan) {
+	saved := &plan.Rebalance{
+		Created: time.Now(),
+		Hosts:   r.nodeup.Hosts,
+		VMCount: status.vmcount,
+		PerHost: r.calculateBestWedth(status),
+		Migrate: p.Migrate,
+	}
+	for _, hypervisor := range status.hypervisors {
+		saved.Hypervisors = append(saved.Hypervisors, plan.Hypervisor{
+			Name:    hypervisor.name,
+			Count:   hypervisor.count,
+			Servers: hypervisor.vms,
+		})
+	}
+
+	err := saved.Save(path)
+	if err != nil {
+		r.Log().Fatalf("Can't save plan: %s", err)
+	}
+	r.Log().Infof("Migration plan for %d server(s) saved to %s", len(saved.Migrate), path)
+}
+
+// Saved plan is applied only if no server has moved since it was calculated
+func (r *Rebalance) applyPlan(path string) {
+	saved, err := r.loadPlan(path)
+	if err != nil {
+		r.Log().Fatal(err)
+	}
+
+	if r.nodeup.DryRun {
+		r.nodeup.PrintPlan(&plan.Plan{Mode: "rebalance", Migrate: saved.Migrate})
 		os.Exit(0)
 	}
-	migrationPlan := r.findMigration(r.calculateUsage(servers))
-	r.rebalance(migrationPlan)
+	if len(saved.Migrate) == 0 {
+		r.Log().Info("Nothing to migrate")
+		os.Exit(0)
+	}
+	r.rebalance(saved.Migrate)
+}
+
+// loadPlan reads saved plan and compares it with current placement of its servers
+func (r *Rebalance) loadPlan(path string) (*plan.Rebalance, error) {
+	saved, err := plan.LoadRebalance(path)
+	if err != nil {
+		return nil, err
+	}
+	r.Log().Infof("Applying migration plan %s created %s", path, saved.Created.Format(time.RFC3339))
+
+	servers, err := r.servers(saved.Hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	if moved := outdated(saved, servers); len(moved) > 0 {
+		r.Log().Errorf("Plan %s is outdated, nothing was migrated", path)
+		for _, message := range moved {
+			r.Log().Errorf(" - %s", message)
+		}
+		return nil, fmt.Errorf("plan %s is outdated, please create it again", path)
+	}
+	return saved, nil
+}
+
+// outdated compares placement saved in plan with current servers
+func outdated(saved *plan.Rebalance, servers []openstack.Server) []string {
+	expected := map[string][]string{}
+	for _, hypervisor := range saved.Hypervisors {
+		for _, name := range hypervisor.Servers {
+			expected[name] = append(expected[name], hypervisor.Name)
+		}
+	}
+	current := map[string][]string{}
+	byID := map[string]openstack.Server{}
+	for _, server := range servers {
+		current[server.Name] = append(current[server.Name], server.HypervisorName)
+		byID[server.ID] = server
+	}
+
+	var names []string
+	for name := range expected {
+		names = append(names, name)
+	}
+	for name := range current {
+		if _, ok := expected[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var moved []string
+	for _, name := range names {
+		was, now := expected[name], current[name]
+		sort.Strings(was)
+		sort.Strings(now)
+		switch {
+		case strings.Join(was, ",") == strings.Join(now, ","):
+		case len(was) == 0:
+			moved = append(moved, fmt.Sprintf("%s appeared on %s", name, strings.Join(now, ",")))
+		case len(now) == 0:
+			moved = append(moved, fmt.Sprintf("%s disappeared from %s", name, strings.Join(was, ",")))
+		default:
+			moved = append(moved, fmt.Sprintf("%s is on %s, plan expects %s", name, strings.Join(now, ","), strings.Join(was, ",")))
+		}
+	}
+
+	for _, m := range saved.Migrate {
+		server, ok := byID[m.ServerID]
+		if !ok {
+			moved = append(moved, fmt.Sprintf("%s (%s) is not found", m.Server, m.ServerID))
+			continue
+		}
+		if server.Name != m.Server || server.HypervisorName != m.From {
+			moved = append(moved, fmt.Sprintf("%s (%s) is on %s, plan expects %s", server.Name, m.ServerID, server.HypervisorName, m.From))
+		}
+	}
+	return moved
+}
+
+func (r *Rebalance) plan(migrationPlan map[string]string, servers []openstack.Server) *plan.Plan {
+	p := plan.New("rebalance")
+	for _, server := range servers {
+		if hypervisorName, ok := migrationPlan[server.Name]; ok {
+			p.Migrate = append(p.Migrate, plan.Move{
+				Server:   server.Name,
+				ServerID: server.ID,
+				From:     server.HypervisorName,
+				To:       hypervisorName,
+			})
+		}
+	}
+	return p
 }
 
 func (r *Rebalance) calculateUsage(servers []openstack.Server) (*hypervisorsStatistics, map[string][]string) {
@@ -141,6 +285,11 @@ func (r *Rebalance) findMigration(status *hypervisorsStatistics, vmByHypervisor
 
 	if len(migrateVMList) == 0 {
 		r.Log().Info("Nothing to migrate")
+		if r.nodeup.DryRun {
+			r.nodeup.PrintPlan(plan.New("rebalance"))
+		} else if r.nodeup.PlanOut != "" {
+			r.savePlan(r.nodeup.PlanOut, status, plan.New("rebalance"))
+		}
 		os.Exit(0)
 	}
 
@@ -161,25 +310,34 @@ outer:
 	return migratePlan
 }
 
-func (r *Rebalance) rebalance(migrationPlan map[string]string) {
-	var wg sync.WaitGroup
-
-	for hostname, hypervisorName := range migrationPlan {
-		r.Log().Infof("Searching ID for host %s", hostname)
-		hostID, err := r.nodeup.Openstack.IDFromName(hostname)
-		if err != nil {
-			r.Log().Fatal(err)
-		}
-		r.Log().Infof("HostID for host %s: %s", hostname, hostID)
+func (r *Rebalance) rebalance(moves []plan.Move) {
+	r.nodeup.Exitcode = r.migrate(moves)
+	if r.nodeup.Stopped() {
+		r.Log().Error("Rebalance was interrupted")
+		r.nodeup.Exitcode = 1
+	}
+	os.Exit(r.nodeup.Exitcode)
+}
 
-		r.Log().Debugf("Starting goroutine for host %s", hostname)
-		wg.Add(1)
-		if !r.nodeup.Openstack.MigrateHost(hostID, hypervisorName, &wg) {
-			r.nodeup.Exitcode = 1
+// migrate moves servers by ID from plan, names may be not unique
+func (r *Rebalance) migrate(moves []plan.Move) int {
+	var mutex sync.Mutex
+	exitcode := 0
+	p := r.nodeup.NewPool()
+	for _, m := range moves {
+		m := m
+		r.Log().Debugf("Starting worker for host %s (%s)", m.Server, m.ServerID)
+		started := p.Go(func() {
+			if !r.nodeup.Openstack.MigrateHost(r.nodeup.Context(), m.ServerID, m.To) {
+				mutex.Lock()
+				exitcode = 1
+				mutex.Unlock()
+			}
+		})
+		if !started {
+			break
 		}
-
 	}
-	r.Log().Debug("Waiting for workers to finish")
-	wg.Wait()
-	os.Exit(r.nodeup.Exitcode)
+	p.Wait()
+	return exitcode
 }
@@ -0,0 +1,152 @@
+package rebalance
+
+import (
+	"context"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/onetwotrip/nodeup/pkg/nodeup"
+	"github.com/onetwotrip/nodeup/pkg/openstack"
+	"github.com/onetwotrip/nodeup/pkg/plan"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// fakeOpenstack keeps server placement, names may be duplicated
+type fakeOpenstack struct {
+	openstack.Cloud
+
+	mutex    sync.Mutex
+	servers  []openstack.Server
+	migrated []string
+}
+
+func (f *fakeOpenstack) GetServers() ([]servers.Server, error) {
+	var result []servers.Server
+	for _, s := range f.servers {
+		result = append(result, servers.Server{ID: s.ID, Name: s.Name})
+	}
+	return result, nil
+}
+
+func (f *fakeOpenstack) GetServerDetail(sid string) (openstack.Server, error) {
+	for _, s := range f.servers {
+		if s.ID == sid {
+			return s, nil
+		}
+	}
+	return openstack.Server{}, os.ErrNotExist
+}
+
+func (f *fakeOpenstack) MigrateHost(ctx context.Context, id string, hypervisor string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.migrated = append(f.migrated, id+"->"+hypervisor)
+	return true
+}
+
+func (f *fakeOpenstack) move(id string, hypervisor string) {
+	for i := range f.servers {
+		if f.servers[i].ID == id {
+			f.servers[i].HypervisorName = hypervisor
+		}
+	}
+}
+
+func testRebalance() (*Rebalance, *fakeOpenstack) {
+	fake := &fakeOpenstack{servers: []openstack.Server{
+		{ID: "id-1", Name: "search-1", HypervisorName: "hv1"},
+		{ID: "id-2", Name: "search-2", HypervisorName: "hv1"},
+		{ID: "id-3", Name: "search-3", HypervisorName: "hv1"},
+		{ID: "id-4", Name: "search-4", HypervisorName: "hv2"},
+		{ID: "id-5", Name: "backend-1", HypervisorName: "hv2"},
+	}}
+	o := nodeup.New("test", logrus.NewEntry(logrus.New()))
+	o.Openstack = fake
+	o.Hosts = "search"
+	o.Concurrency = 2
+	return New(o), fake
+}
+
+func savedPlan(t *testing.T, r *Rebalance, dir string) string {
+	servers, err := r.servers(r.nodeup.Hosts)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(servers))
+
+	status, _ := r.calculateUsage(servers)
+	p := r.plan(map[string]string{"search-1": "hv2"}, servers)
+	path := filepath.Join(dir, "plan.json")
+	r.savePlan(path, status, p)
+	return path
+}
+
+func TestSavePlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeup")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	r, _ := testRebalance()
+	path := savedPlan(t, r, dir)
+
+	saved, err := plan.LoadRebalance(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "search", saved.Hosts)
+	assert.Equal(t, 4, saved.VMCount)
+	assert.Equal(t, 2, saved.PerHost)
+	assert.Equal(t, []plan.Move{{Server: "search-1", ServerID: "id-1", From: "hv1", To: "hv2"}}, saved.Migrate)
+	assert.Equal(t, 2, len(saved.Hypervisors))
+
+	loaded, err := r.loadPlan(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, saved.Migrate, loaded.Migrate)
+
+	_, err = plan.LoadRebalance(filepath.Join(dir, "missing.json"))
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, nil, ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0644))
+	_, err = plan.LoadRebalance(filepath.Join(dir, "broken.json"))
+	assert.NotEqual(t, nil, err)
+}
+
+func TestLoadPlanOutdated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeup")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	// Server which is not in migration list has moved
+	r, fake := testRebalance()
+	path := savedPlan(t, r, dir)
+	fake.move("id-3", "hv2")
+	_, err = r.loadPlan(path)
+	assert.NotEqual(t, nil, err)
+
+	saved, err := plan.LoadRebalance(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"search-3 is on hv2, plan expects hv1"}, outdated(saved, fake.servers[:4]))
+
+	// Server was added and other one was deleted
+	r, fake = testRebalance()
+	fake.servers = append(fake.servers[:3], openstack.Server{ID: "id-6", Name: "search-5", HypervisorName: "hv2"})
+	assert.Equal(t, []string{"search-4 disappeared from hv2", "search-5 appeared on hv2"}, outdated(saved, fake.servers))
+
+	// Server from migration list was recreated with the same name
+	r, fake = testRebalance()
+	fake.servers[0].ID = "id-7"
+	assert.Equal(t, []string{"search-1 (id-1) is not found"}, outdated(saved, fake.servers[:4]))
+}
+
+func TestMigrateByID(t *testing.T) {
+	r, fake := testRebalance()
+	fake.servers = append(fake.servers, openstack.Server{ID: "id-8", Name: "search-1", HypervisorName: "hv1"})
+
+	exitcode := r.migrate([]plan.Move{
+		{Server: "search-1", ServerID: "id-8", From: "hv1", To: "hv2"},
+		{Server: "search-2", ServerID: "id-2", From: "hv1", To: "hv2"},
+	})
+	assert.Equal(t, 0, exitcode)
+	sort.Strings(fake.migrated)
+	assert.Equal(t, []string{"id-2->hv2", "id-8->hv2"}, fake.migrated)
+}
@@ -6,7 +6,7 @@ import (
 )
 
 type Rebalance struct {
-	nodeup nodeup.NodeUP
+	nodeup *nodeup.NodeUP
 	log    *logrus.Entry
 }
 
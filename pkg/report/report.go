@@ -0,0 +1,143 @@
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+func New(mode string, started time.Time) *Report {
+	return &Report{
+		Mode:    mode,
+		Started: started,
+		Success: true,
+	}
+}
+
+// Add appends host, report is failed unless all hosts succeeded
+func (r *Report) Add(h Host) {
+	if h.Status != StatusSuccess {
+		r.Success = false
+	}
+	r.Hosts = append(r.Hosts, h)
+}
+
+// Fail marks report as failed with error which is not related to any host
+func (r *Report) Fail(err string) {
+	r.Success = false
+	r.Error = err
+}
+
+// Save writes report to path in json or junit format
+func (r *Report) Save(path string, format string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = r.Write(f, format)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Write writes report as json or junit xml
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "json", "":
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case "junit":
+		data, err := xml.MarshalIndent(r.junit(), "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, data)
+		return err
+	}
+	return fmt.Errorf("unknown report format %s", format)
+}
+
+// junit returns test case for every host
+func (r *Report) junit() junitSuites {
+	suite := junitSuite{
+		Name:      "nodeup." + r.Mode,
+		Tests:     len(r.Hosts),
+		Time:      seconds(r.Duration),
+		Timestamp: r.Started.UTC().Format("2006-01-02T15:04:05"),
+	}
+	for _, h := range r.Hosts {
+		c := junitCase{
+			ClassName: suite.Name,
+			Name:      h.Hostname,
+			Time:      seconds(h.Duration),
+			SystemOut: h.details(),
+		}
+		switch h.Status {
+		case StatusSuccess:
+		case StatusSkipped:
+			suite.Skipped++
+			c.Skipped = &junitSkipped{Message: "not started"}
+		default:
+			suite.Failures++
+			c.Failure = &junitFailure{
+				Message: fmt.Sprintf("%s at step %s: %s", h.Status, h.FailedStep, h.Error),
+				Type:    h.FailedStep,
+				Text:    h.Error,
+			}
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	// Run error is shown as failed test case named after mode
+	if r.Error != "" {
+		suite.Tests++
+		suite.Failures++
+		suite.Cases = append(suite.Cases, junitCase{
+			ClassName: suite.Name,
+			Name:      r.Mode,
+			Time:      seconds(r.Duration),
+			Failure: &junitFailure{
+				Message: r.Error,
+				Type:    "error",
+				Text:    r.Error,
+			},
+		})
+	}
+	return junitSuites{Suites: []junitSuite{suite}}
+}
+
+// details are server, steps and log shown by CI for test case
+func (h Host) details() string {
+	var lines []string
+	if h.ServerID != "" {
+		lines = append(lines, fmt.Sprintf("server: %s (%s)", h.ServerID, strings.Join(h.Addresses, ",")))
+	}
+	lines = append(lines, "flavor: "+h.Flavor)
+	if h.Hypervisor != "" {
+		lines = append(lines, "hypervisor: "+h.Hypervisor)
+	}
+	for _, s := range h.Steps {
+		line := fmt.Sprintf("step %s: %ss", s.Name, seconds(s.Duration))
+		if s.Error != "" {
+			line += " error: " + s.Error
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "log: "+h.Log)
+	if h.LogURL != "" {
+		lines = append(lines, "log url: "+h.LogURL)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func seconds(d float64) string {
+	return fmt.Sprintf("%.3f", d)
+}
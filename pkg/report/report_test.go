@@ -0,0 +1,128 @@
+package report
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func testReport() *Report {
+	r := New("create", time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC))
+	r.Add(Host{
+		Hostname:  "search-ab12c",
+		ServerID:  "id-1",
+		Addresses: []string{"8.8.8.8"},
+		Flavor:    "4x8192",
+		Status:    StatusSuccess,
+		Duration:  12.5,
+		Steps:     []Step{{Name: "create", Duration: 12.5}},
+		Log:       "logs/search-ab12c.log",
+	})
+	r.Duration = 30
+	return r
+}
+
+func TestWriteJson(t *testing.T) {
+	r := testReport()
+	assert.True(t, r.Success)
+
+	var buf bytes.Buffer
+	assert.Equal(t, nil, r.Write(&buf, "json"))
+	testData := `{
+  "mode": "create",
+  "started": "2021-05-01T10:00:00Z",
+  "duration": 30,
+  "success": true,
+  "hosts": [
+    {
+      "hostname": "search-ab12c",
+      "server_id": "id-1",
+      "addresses": [
+        "8.8.8.8"
+      ],
+      "flavor": "4x8192",
+      "status": "success",
+      "duration": 12.5,
+      "steps": [
+        {
+          "name": "create",
+          "duration": 12.5
+        }
+      ],
+      "log": "logs/search-ab12c.log"
+    }
+  ]
+}
+`
+	assert.Equal(t, testData, buf.String())
+	assert.NotEqual(t, nil, r.Write(&buf, "yaml"))
+}
+
+func TestWriteJunit(t *testing.T) {
+	r := testReport()
+	r.Add(Host{
+		Hostname:   "search-cd34e",
+		Flavor:     "4x8192",
+		Status:     StatusFailed,
+		FailedStep: "wait-active",
+		Error:      "server status is ERROR",
+		Duration:   3,
+		Log:        "logs/search-cd34e.log",
+	})
+	r.Add(Host{Hostname: "search-ef56g", Flavor: "4x8192", Status: StatusSkipped, Log: "logs/search-ef56g.log"})
+	assert.False(t, r.Success)
+
+	var buf bytes.Buffer
+	assert.Equal(t, nil, r.Write(&buf, "junit"))
+	testData := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="nodeup.create" tests="3" failures="1" skipped="1" time="30.000" timestamp="2021-05-01T10:00:00">
+    <testcase classname="nodeup.create" name="search-ab12c" time="12.500">
+      <system-out>server: id-1 (8.8.8.8)&#xA;flavor: 4x8192&#xA;step create: 12.500s&#xA;log: logs/search-ab12c.log</system-out>
+    </testcase>
+    <testcase classname="nodeup.create" name="search-cd34e" time="3.000">
+      <failure message="failed at step wait-active: server status is ERROR" type="wait-active">server status is ERROR</failure>
+      <system-out>flavor: 4x8192&#xA;log: logs/search-cd34e.log</system-out>
+    </testcase>
+    <testcase classname="nodeup.create" name="search-ef56g" time="0.000">
+      <skipped message="not started"></skipped>
+      <system-out>flavor: 4x8192&#xA;log: logs/search-ef56g.log</system-out>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+	assert.Equal(t, testData, buf.String())
+}
+
+func TestWriteError(t *testing.T) {
+	r := New("create", time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC))
+	r.Fail("preflight check failed: flavor 4x8192 is not found")
+	assert.False(t, r.Success)
+
+	var buf bytes.Buffer
+	assert.Equal(t, nil, r.Write(&buf, "json"))
+	testData := `{
+  "mode": "create",
+  "started": "2021-05-01T10:00:00Z",
+  "duration": 0,
+  "success": false,
+  "hosts": null,
+  "error": "preflight check failed: flavor 4x8192 is not found"
+}
+`
+	assert.Equal(t, testData, buf.String())
+
+	buf.Reset()
+	assert.Equal(t, nil, r.Write(&buf, "junit"))
+	testData = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="nodeup.create" tests="1" failures="1" skipped="0" time="0.000" timestamp="2021-05-01T10:00:00">
+    <testcase classname="nodeup.create" name="create" time="0.000">
+      <failure message="preflight check failed: flavor 4x8192 is not found" type="error">preflight check failed: flavor 4x8192 is not found</failure>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+	assert.Equal(t, testData, buf.String())
+}
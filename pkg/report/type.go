@@ -0,0 +1,82 @@
+package report
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Host statuses
+const (
+	StatusSuccess     = "success"
+	StatusFailed      = "failed"
+	StatusInterrupted = "interrupted"
+	// StatusSkipped is set for hosts not started after interrupt
+	StatusSkipped = "skipped"
+)
+
+// Report is result of one nodeup run for CI
+type Report struct {
+	Mode     string    `json:"mode"`
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration"`
+	Success  bool      `json:"success"`
+	Hosts    []Host    `json:"hosts"`
+	// Error fails the whole run, like preflight check
+	Error string `json:"error,omitempty"`
+}
+
+// Host durations are in seconds
+type Host struct {
+	Hostname   string   `json:"hostname"`
+	ServerID   string   `json:"server_id,omitempty"`
+	Addresses  []string `json:"addresses,omitempty"`
+	Flavor     string   `json:"flavor"`
+	Hypervisor string   `json:"hypervisor,omitempty"`
+	Status     string   `json:"status"`
+	FailedStep string   `json:"failed_step,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	Duration   float64  `json:"duration"`
+	Steps      []Step   `json:"steps,omitempty"`
+	Log        string   `json:"log"`
+	LogURL     string   `json:"log_url,omitempty"`
+}
+
+type Step struct {
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
@@ -1,22 +1,44 @@
 package rest
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/labstack/echo"
 	"github.com/labstack/gommon/log"
+	"github.com/onetwotrip/nodeup/pkg/chef"
+	"github.com/onetwotrip/nodeup/pkg/jobs"
 	"github.com/onetwotrip/nodeup/pkg/nodeup"
 	"github.com/onetwotrip/nodeup/pkg/ssh"
 	"github.com/patrickmn/go-cache"
+	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 func Init(n *nodeup.NodeUP) *Echo {
+	store, err := jobs.New(n, n.JobsDB)
+	if err != nil {
+		n.Log().Fatalf("Can't open jobs database %s: %s", n.JobsDB, err)
+	}
+
 	e := &Echo{
 		echo.New(),
 		n,
 		cache.New(60*time.Minute, 120*time.Minute),
+		store,
+		sync.Mutex{},
+	}
+
+	if _, err := os.Stat(n.LogDir); os.IsNotExist(err) {
+		err = os.MkdirAll(n.LogDir, 0775)
+		if err != nil {
+			n.Log().Errorf("Couldn't create a logs directory: %s", err)
+		}
 	}
 
 	e.Logger.SetLevel(log.INFO)
@@ -39,14 +61,21 @@ func Init(n *nodeup.NodeUP) *Echo {
 	e.POST("/api/servers/:id/stop", e.stopServer)
 	e.POST("/api/servers/:id/chef", e.serverChefRun)
 	e.GET("/api/servers/:id/action", e.serverActionStatus)
+	e.GET("/api/servers/:id/jobs", e.getServerJobs)
 	e.GET("/api/servers/:name/hypervisor/cache", e.serverGetHypervisorNameCache)
 
 	// Flavors methods
 	e.GET("/api/flavors", e.getFlavors)
 	e.GET("/api/flavors/:id", e.getFlavorInfo)
 
+	// Jobs methods
+	e.GET("/api/jobs", e.getJobs)
+	e.GET("/api/jobs/:id", e.getJob)
+	e.GET("/api/jobs/:id/log/stream", e.streamJobLog)
+
 	// Management Methods
-	e.POST("/api/setupHost", setupHost)
+	e.POST("/api/setupHost", e.setupHost)
+	e.GET("/api/setupHost/:id", e.setupHostStatus)
 
 	e.Logger.Fatal(e.Start(":8080"))
 
@@ -57,18 +86,143 @@ func ping(c echo.Context) error {
 	return c.JSON(http.StatusOK, "pong")
 }
 
-func setupHost(c echo.Context) error {
+func (e *Echo) setupHost(c echo.Context) error {
 	// HTTP POST
-	// Role - Chef Role (require)
-	// Environment - Chef Environment (require)
+	// Role - Chef Role (require without policy)
+	// Environment - Chef Environment (require without policy)
+	// Recipes - Additional run-list recipes (optional)
+	// Attributes - First-boot normal attributes (optional)
+	// PolicyName, PolicyGroup - Policyfile mode instead of role and environment (optional)
 	// Sensitive - cpu/memory/disk (optional)
+	// Name - Hostname or mask like role-environment-* (optional)
 	h := new(SetupHost)
 	if err := c.Bind(h); err != nil {
 		return err
 	}
-	//Some logic
+	policy := h.PolicyName != "" || h.PolicyGroup != ""
+	if policy && (h.PolicyName == "" || h.PolicyGroup == "") {
+		return c.JSON(http.StatusBadRequest, e.simpleMessage("", "policy_name and policy_group are required together"))
+	}
+	if policy && (h.Role != "" || len(h.Recipes) > 0 || h.Environment != "") {
+		return c.JSON(http.StatusBadRequest, e.simpleMessage("", "policy can't be used with role, recipes or environment"))
+	}
+	if !policy && (h.Role == "" || h.Environment == "") {
+		return c.JSON(http.StatusBadRequest, e.simpleMessage("", "role and environment are required"))
+	}
+	if e.nodeup.Domain == "" || e.nodeup.OSFlavorName == "" {
+		return c.JSON(http.StatusInternalServerError, e.simpleMessage("", "Daemon started without -domain or -flavor"))
+	}
+
+	if h.Name == "" && policy {
+		h.Name = h.PolicyName + "-" + h.PolicyGroup + "-*"
+	} else if h.Name == "" {
+		h.Name = h.Role + "-" + h.Environment + "-*"
+	}
+	host := e.nodeup.NewHost("")
+	host.ChefRunList = nil
+	if !policy {
+		host.ChefRunList = chef.RunList([]string{h.Role}, h.Recipes)
+	}
+	host.ChefAttributes = h.Attributes
+	host.ChefEnvironment = h.Environment
+	host.ChefPolicyName = h.PolicyName
+	host.ChefPolicyGroup = h.PolicyGroup
+
+	if errs := e.nodeup.Preflight(host); len(errs) > 0 {
+		var messages []string
+		for _, err := range errs {
+			messages = append(messages, err.Error())
+		}
+		return c.JSON(http.StatusBadRequest, e.simpleMessage("Preflight check failed", strings.Join(messages, "; ")))
+	}
+
+	var hypervisor string
+	if h.Sensitive != "" {
+		hypervisor = e.nodeup.Openstack.GetHypervisorWithSensitiveCriteria(h.Sensitive).Service.Host
+		if hypervisor == "" {
+			return c.JSON(http.StatusServiceUnavailable, e.simpleMessage("", "Can't find hypervisor for criteria "+h.Sensitive))
+		}
+
+		// Nova places server to exact host with zone:host availability zone
+		zone := host.AvailabilityZone
+		if zone == "" {
+			zone = "nova"
+		}
+		host.AvailabilityZone = zone + ":" + hypervisor
+	}
+
+	job, status, err := e.reserveSetup(c, h.Name, host)
+	if err != nil {
+		return c.JSON(status, e.simpleMessage("Can't generate hostname", err.Error()))
+	}
+	if hypervisor != "" {
+		e.jobEvent(job.ID, "Hypervisor "+hypervisor)
+	}
+
+	// Server is visible in /api/servers/:id/jobs while bootstrap is running
+	host.Created = func(host *nodeup.Host) {
+		err := e.jobs.Update(job.ID, func(j *jobs.Job) {
+			j.ServerID = host.ServerID
+		})
+		if err != nil {
+			e.Logger.Error(err)
+		}
+	}
 
-	return c.JSON(http.StatusCreated, h)
+	go func(host *nodeup.Host) {
+		exitStatus := 0
+		if !e.nodeup.BootstrapHost(host) {
+			exitStatus = 1
+		}
+		e.finishJob(job.ID, exitStatus, "")
+	}(host)
+
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// reserveSetup generates hostname and creates running setup job with it
+// Names of running setup jobs are reserved, so concurrent requests get different names
+func (e *Echo) reserveSetup(c echo.Context, name string, host *nodeup.Host) (*jobs.Job, int, error) {
+	e.setup.Lock()
+	defer e.setup.Unlock()
+
+	running, err := e.jobs.Running("setup")
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	var reserved []string
+	for _, j := range running {
+		reserved = append(reserved, j.Hostname)
+	}
+	hostnames, err := e.nodeup.Hostnames(name, 1, host, reserved...)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	host.Hostname = hostnames[0]
+	host.LogFile = e.nodeup.NewHost(host.Hostname).LogFile
+
+	job := e.newJob(c, "setup", "")
+	job.Hostname = host.Hostname
+	job.LogFile = host.LogFile
+	if err := e.jobs.Create(job); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return job, http.StatusAccepted, nil
+}
+
+// Setup host job status, same as /api/jobs/:id for setup jobs
+func (e *Echo) setupHostStatus(c echo.Context) error {
+	job, err := e.jobs.Get(c.Param("id"))
+	if err == nil && job.Action != "setup" {
+		err = jobs.ErrNotFound
+	}
+	if err == jobs.ErrNotFound {
+		return c.JSON(http.StatusNotFound, e.simpleMessage("", err.Error()))
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, e.simpleMessage("", err.Error()))
+	}
+	return c.JSON(http.StatusOK, job)
 }
 
 // Get Hypervisors list
@@ -153,27 +307,41 @@ func (e *Echo) startServer(c echo.Context) error {
 		return c.JSON(http.StatusConflict, e.simpleMessage("", "Server already running"))
 	}
 
-	if e.isAlreadyInProgress(c.Param("id"), "start") {
+	if e.jobs.IsRunning(c.Param("id"), "start") {
 		return c.JSON(http.StatusOK, e.simpleMessage("", "Starting already running"))
 	}
-	if e.isAlreadyInProgress(c.Param("id"), "stop") {
+	if e.jobs.IsRunning(c.Param("id"), "stop") {
 		return c.JSON(http.StatusOK, e.simpleMessage("", "Shutdown already running"))
 	}
 
+	job := e.newJob(c, "start", c.Param("id"))
+	job.Hostname = server.Name
+	if err := e.jobs.Create(job); err != nil {
+		return c.JSON(http.StatusInternalServerError, e.simpleMessage("", err.Error()))
+	}
+
 	err = e.nodeup.Openstack.StartServer(c.Param("id"))
 	if err != nil {
+		e.finishJob(job.ID, 1, err.Error())
 		return c.JSON(http.StatusInternalServerError, err)
 	}
-	e.saveState(c.Param("id"), "start", 99)
+	e.finishJob(job.ID, 0, "")
 	return c.JSON(http.StatusOK, "ok")
 }
 
 // Servers (VM) Stop
 func (e *Echo) stopServer(c echo.Context) error {
+	job := e.newJob(c, "stop", c.Param("id"))
+	if err := e.jobs.Create(job); err != nil {
+		return c.JSON(http.StatusInternalServerError, e.simpleMessage("", err.Error()))
+	}
+
 	err := e.nodeup.Openstack.StopServer(c.Param("id"))
 	if err != nil {
+		e.finishJob(job.ID, 1, err.Error())
 		return c.JSON(http.StatusInternalServerError, e.simpleMessage("", err.Error()))
 	}
+	e.finishJob(job.ID, 0, "")
 	return c.JSON(http.StatusOK, "ok")
 }
 
@@ -214,18 +382,148 @@ func (e *Echo) serverActionStatus(c echo.Context) error {
 	return c.JSON(http.StatusOK, e.getState(id, action))
 }
 
+// Get Jobs list
+func (e *Echo) getJobs(c echo.Context) error {
+	list, err := e.jobs.List()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, e.simpleMessage("", "Can't get jobs list"))
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// Get Job
+func (e *Echo) getJob(c echo.Context) error {
+	job, err := e.jobs.Get(c.Param("id"))
+	if err == jobs.ErrNotFound {
+		return c.JSON(http.StatusNotFound, e.simpleMessage("", err.Error()))
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, e.simpleMessage("", err.Error()))
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// Stream Job log with Server-Sent Events
+// Every event id is a log offset, use ?offset= or Last-Event-ID header to replay from it
+func (e *Echo) streamJobLog(c echo.Context) error {
+	job, err := e.jobs.Get(c.Param("id"))
+	if err == jobs.ErrNotFound {
+		return c.JSON(http.StatusNotFound, e.simpleMessage("", err.Error()))
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, e.simpleMessage("", err.Error()))
+	}
+	if job.LogFile == "" {
+		return c.JSON(http.StatusNotFound, e.simpleMessage("", "Job has no log"))
+	}
+
+	offsetParam := c.QueryParam("offset")
+	if offsetParam == "" {
+		offsetParam = c.Request().Header.Get("Last-Event-ID")
+	}
+	var offset int64
+	if offsetParam != "" {
+		offset, err = strconv.ParseInt(offsetParam, 10, 64)
+		if err != nil || offset < 0 {
+			return c.JSON(http.StatusBadRequest, e.simpleMessage("", "offset is not valid"))
+		}
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	var file *os.File
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		// Job from previous tick is kept on transient store errors
+		latest, err := e.jobs.Get(job.ID)
+		if err == jobs.ErrNotFound {
+			fmt.Fprintf(res, "event: error\ndata: {\"error\":%q}\n\n", "job is not found")
+			res.Flush()
+			return nil
+		}
+		if err == nil {
+			job = latest
+		} else {
+			e.Logger.Errorf("Can't get job %s: %s", job.ID, err)
+		}
+		finished := job.State != jobs.StateRunning
+
+		if file == nil {
+			file, err = os.Open(job.LogFile)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		n := 0
+		if file != nil {
+			n, err = file.ReadAt(buf, offset)
+			if err != nil && err != io.EOF {
+				return err
+			}
+		}
+
+		// Send whole lines only while log is still written
+		chunk := buf[:n]
+		if !finished && n < len(buf) {
+			chunk = chunk[:bytes.LastIndexByte(chunk, '\n')+1]
+		}
+
+		if len(chunk) > 0 {
+			offset += int64(len(chunk))
+			fmt.Fprintf(res, "id: %d\nevent: log\n", offset)
+			for _, line := range bytes.Split(bytes.TrimSuffix(chunk, []byte("\n")), []byte("\n")) {
+				fmt.Fprintf(res, "data: %s\n", line)
+			}
+			fmt.Fprint(res, "\n")
+			res.Flush()
+			continue
+		}
+
+		if finished {
+			fmt.Fprintf(res, "event: end\ndata: {\"state\":%q,\"exit_status\":%d}\n\n", job.State, job.ExitStatus)
+			res.Flush()
+			return nil
+		}
+
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Get Server (VM) jobs list
+func (e *Echo) getServerJobs(c echo.Context) error {
+	list, err := e.jobs.ListByServer(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, e.simpleMessage("", "Can't get jobs list"))
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
 // Servers Chef Run
 func (e *Echo) serverChefRun(c echo.Context) error {
 	id := c.Param("id")
-	if e.isAlreadyInProgress(id, "chef") {
+	if e.jobs.IsRunning(id, "chef") {
 		return c.JSON(http.StatusConflict, e.simpleMessage("", "Already running"))
 	}
 
-	logFile, err := os.Create("logs/" + id + ".log")
-	if err != nil {
-		c.Logger().Error(err)
-	}
-
 	//Get Server IP Information
 	server, err := e.nodeup.Openstack.GetServer(c.Param("id"))
 	if err != nil {
@@ -233,25 +531,50 @@ func (e *Echo) serverChefRun(c echo.Context) error {
 	}
 
 	//Get Server Public/Private Address for SSH connection
-	ipAddresses := e.nodeup.GetAddress(server.Addresses)
+	ipAddresses, _ := e.nodeup.GetAddress(server.Addresses)
 	e.Logger.Info(ipAddresses)
 	for _, ipAddress := range ipAddresses {
-		sshClient, err := ssh.New(e.nodeup, ipAddress, e.nodeup.WebSSHUser)
+		sshClient, err := ssh.New(e.nodeup, ipAddress, e.nodeup.WebSSHUser, e.nodeup.SSHConfig())
 		if err != nil {
 			e.Logger.Error(err)
 			continue
 		} else {
+			job := e.newJob(c, "chef", id)
+			job.Hostname = server.Name
+			job.LogFile = filepath.Join(e.nodeup.LogDir, job.ID+".log")
+
+			logFile, err := os.Create(job.LogFile)
+			if err != nil {
+				sshClient.Close()
+				return c.JSON(http.StatusInternalServerError, e.simpleMessage("", err.Error()))
+			}
+			if err := e.jobs.Create(job); err != nil {
+				logFile.Close()
+				sshClient.Close()
+				return c.JSON(http.StatusInternalServerError, e.simpleMessage("", err.Error()))
+			}
+
 			// Use --force-formatter for stdout via ssh. https://github.com/chef/chef-provisioning/issues/274
-			go func(command string) {
-				e.saveState(id, "chef", 99)
-				err := sshClient.RunCommandPipe(command, logFile)
+			go func(command ssh.Command) {
+				defer logFile.Close()
+				defer sshClient.Close()
+				command.Output = logFile
+				result, err := sshClient.Run(e.nodeup.Context(), command)
 				if err != nil {
-					e.saveState(id, "chef", 1)
+					exitStatus := result.ExitCode
+					if exitStatus <= 0 {
+						exitStatus = 1
+					}
+					e.finishJob(job.ID, exitStatus, err.Error())
 				} else {
-					e.saveState(id, "chef", 0)
+					e.finishJob(job.ID, 0, "")
 				}
-			}("sudo chef-client -L STDOUT --no-fork --force-formatter")
-			return c.JSON(http.StatusOK, "ok")
+			}(ssh.Command{
+				Name:    "chef-client",
+				Cmd:     "sudo chef-client -L STDOUT --no-fork --force-formatter",
+				Timeout: time.Duration(e.nodeup.SSHCommandTimeout) * time.Minute,
+			})
+			return c.JSON(http.StatusOK, job)
 		}
 	}
 	return c.JSON(http.StatusInternalServerError, e.simpleMessage("", "chef run connect error"))
@@ -276,13 +599,6 @@ func (e *Echo) getFlavorInfo(c echo.Context) error {
 	return c.JSON(http.StatusOK, flavorInfo)
 }
 
-// Save action state
-func (e *Echo) saveState(id string, action string, state int) {
-	e.Logger.Infof("Save action %s with id %s and state %d", action, id, state)
-	data := &Progress{action, state}
-	e.cache.Set(id, data, 60*time.Minute)
-}
-
 // Get action state
 func (e *Echo) getState(id string, action string) *Progress {
 	progress := &Progress{
@@ -290,13 +606,45 @@ func (e *Echo) getState(id string, action string) *Progress {
 		-1,
 	}
 	e.Logger.Infof("Getting action %s with id %s", action, id)
-	if data, found := e.cache.Get(id); found {
-		e.Logger.Infof("Found action %s", action)
-		return data.(*Progress)
+	job, err := e.jobs.Latest(id, action)
+	if err != nil {
+		return progress
+	}
+	e.Logger.Infof("Found action %s", action)
+	if job.State == jobs.StateRunning {
+		progress.State = 99
+	} else {
+		progress.State = job.ExitStatus
 	}
 	return progress
 }
 
+// New job triggered by request
+func (e *Echo) newJob(c echo.Context, action string, serverID string) *jobs.Job {
+	user := c.Request().Header.Get("X-Remote-User")
+	if user == "" {
+		user = c.RealIP()
+	}
+	return &jobs.Job{
+		ID:       jobs.NewID(),
+		Action:   action,
+		ServerID: serverID,
+		User:     user,
+	}
+}
+
+func (e *Echo) jobEvent(id string, message string) {
+	if err := e.jobs.Event(id, message); err != nil {
+		e.Logger.Errorf("Can't save job %s event: %s", id, err)
+	}
+}
+
+func (e *Echo) finishJob(id string, exitStatus int, message string) {
+	if err := e.jobs.Finish(id, exitStatus, message); err != nil {
+		e.Logger.Errorf("Can't finish job %s: %s", id, err)
+	}
+}
+
 // Simple Message
 // {
 //    "message": "Some message",
@@ -305,17 +653,6 @@ func (e *Echo) getState(id string, action string) *Progress {
 func (e *Echo) simpleMessage(message string, error string) *SimpleResponse {
 	return &SimpleResponse{
 		message,
-		"",
-	}
-}
-
-func (e *Echo) isAlreadyInProgress(id string, action string) bool {
-	if data, found := e.cache.Get(id); found {
-		e.Logger.Infof("Found action for %s", id)
-		info := data.(*Progress)
-		if info.State == 99 {
-			return true
-		}
+		error,
 	}
-	return false
 }
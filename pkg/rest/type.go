@@ -1,32 +1,40 @@
 package rest
 
 import (
+	"github.com/onetwotrip/nodeup/pkg/jobs"
 	"github.com/onetwotrip/nodeup/pkg/nodeup"
 	"github.com/labstack/echo"
 	"github.com/patrickmn/go-cache"
+	"sync"
 )
 
 type Echo struct {
 	*echo.Echo
 	nodeup *nodeup.NodeUP
 	cache  *cache.Cache
+	jobs   *jobs.Jobs
+
+	// setup serializes hostname generation with creation of setup job
+	setup sync.Mutex
 }
 
 type SetupHost struct {
 	Role        string `json:"role" xml:"role" form:"role" query:"role"`
 	Environment string `json:"environment" xml:"environment" form:"environment" query:"environment"`
 	Sensitive   string `json:"sensitive" xml:"sensitive" form:"sensitive" query:"sensitive"`
-}
+	Name        string `json:"name" xml:"name" form:"name" query:"name"`
 
-type WebSocketLog struct {
-	Url string
+	Recipes     []string               `json:"recipes" xml:"recipes" form:"recipes" query:"recipes"`
+	Attributes  map[string]interface{} `json:"attributes" xml:"-" form:"-" query:"-"`
+	PolicyName  string                 `json:"policy_name" xml:"policy_name" form:"policy_name" query:"policy_name"`
+	PolicyGroup string                 `json:"policy_group" xml:"policy_group" form:"policy_group" query:"policy_group"`
 }
 
 //Action chef/stop/start...
 //State:
 // 0 - Done with ok
 // 1 - Done with error
-// 100 - In progress
+// 99 - In progress
 
 type Progress struct {
 	Action string `json:"action" xml:"action" form:"action" query:"action"`
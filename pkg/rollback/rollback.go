@@ -0,0 +1,149 @@
+package rollback
+
+import (
+	"fmt"
+	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+)
+
+// Resource kinds
+const (
+	KindServer     = "server"
+	KindKeyPair    = "keypair"
+	KindChefNode   = "chef-node"
+	KindChefClient = "chef-client"
+)
+
+func New(nodeup nodeup.NodeUP, removers map[string]Remover) *Journal {
+	return &Journal{
+		nodeup:    nodeup,
+		removers:  removers,
+		committed: make(map[string]bool),
+	}
+}
+
+// Add records created resource, after Close it is kept or removed at once
+func (j *Journal) Add(r Resource) {
+	j.mutex.Lock()
+	if !j.closed {
+		j.pending = append(j.pending, r)
+		j.mutex.Unlock()
+		return
+	}
+	keep := j.keep
+	if keep {
+		j.kept = append(j.kept, r)
+	}
+	j.mutex.Unlock()
+
+	if !keep {
+		j.remove([]Resource{r})
+	}
+}
+
+// Commit forgets resources of bootstrapped host
+func (j *Journal) Commit(host string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.take(host)
+	j.committed[host] = true
+}
+
+// Keep leaves resources of failed host for investigation or resume
+func (j *Journal) Keep(host string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.kept = append(j.kept, j.take(host)...)
+}
+
+// Rollback removes resources of failed host, returns false if some are left
+func (j *Journal) Rollback(host string) bool {
+	j.mutex.Lock()
+	resources := j.take(host)
+	j.mutex.Unlock()
+	return j.remove(resources)
+}
+
+// Close keeps or removes all pending resources on interrupt
+func (j *Journal) Close(keep bool) {
+	j.mutex.Lock()
+	j.closed = true
+	j.keep = keep
+	resources := j.pending
+	j.pending = nil
+	if keep {
+		j.kept = append(j.kept, resources...)
+	}
+	j.mutex.Unlock()
+
+	if !keep {
+		j.remove(resources)
+	}
+}
+
+// Finish removes shared resources when no host is bootstrapped or kept
+func (j *Journal) Finish() {
+	j.mutex.Lock()
+	used := len(j.committed) > 0 || len(j.kept) > 0
+	j.mutex.Unlock()
+	j.Close(used)
+}
+
+// Report lists removed, kept and failed to remove resources
+func (j *Journal) Report() []Failure {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	for _, r := range j.removed {
+		j.Log().Infof("Removed %s", r)
+	}
+	for _, r := range j.kept {
+		if r.Host != "" {
+			j.Log().Warnf("Kept %s of host %s", r, r.Host)
+		}
+	}
+	for _, f := range j.failed {
+		j.Log().Errorf("Can't remove %s: %s", f.Resource, f.Err)
+	}
+	return j.failed
+}
+
+// take cuts host resources from pending, caller holds mutex
+func (j *Journal) take(host string) []Resource {
+	var resources, pending []Resource
+	for _, r := range j.pending {
+		if r.Host == host {
+			resources = append(resources, r)
+		} else {
+			pending = append(pending, r)
+		}
+	}
+	j.pending = pending
+	return resources
+}
+
+func (j *Journal) remove(resources []Resource) bool {
+	ok := true
+	for i := len(resources) - 1; i >= 0; i-- {
+		r := resources[i]
+		err := j.removeOne(r)
+
+		j.mutex.Lock()
+		if err != nil {
+			ok = false
+			j.failed = append(j.failed, Failure{r, err})
+		} else {
+			j.removed = append(j.removed, r)
+		}
+		j.mutex.Unlock()
+	}
+	return ok
+}
+
+func (j *Journal) removeOne(r Resource) error {
+	remover, found := j.removers[r.Kind]
+	if !found {
+		return fmt.Errorf("no remover for %s", r.Kind)
+	}
+	j.Log().Infof("Removing %s", r)
+	return remover(r)
+}
@@ -0,0 +1,79 @@
+package rollback
+
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testNodeUP struct{}
+
+func (testNodeUP) Version() string    { return "test" }
+func (testNodeUP) Log() *logrus.Entry { return logrus.NewEntry(logrus.New()) }
+
+func newTestJournal(removed *[]string) *Journal {
+	record := func(r Resource) error {
+		*removed = append(*removed, r.Kind+":"+r.Name)
+		return nil
+	}
+	return New(testNodeUP{}, map[string]Remover{
+		KindServer:   record,
+		KindKeyPair:  record,
+		KindChefNode: record,
+		KindChefClient: func(r Resource) error {
+			return errors.New("forbidden")
+		},
+	})
+}
+
+func TestRollback(t *testing.T) {
+	var removed []string
+	j := newTestJournal(&removed)
+	j.Add(Resource{Kind: KindKeyPair, Name: "fox"})
+	j.Add(Resource{Kind: KindServer, ID: "id-1", Name: "search-1", Host: "search-1"})
+	j.Add(Resource{Kind: KindChefNode, Name: "search-1", Host: "search-1"})
+	j.Add(Resource{Kind: KindServer, ID: "id-2", Name: "search-2", Host: "search-2"})
+	j.Add(Resource{Kind: KindChefClient, Name: "search-2", Host: "search-2"})
+
+	assert.True(t, j.Rollback("search-1"))
+	assert.Equal(t, []string{"chef-node:search-1", "server:search-1"}, removed)
+
+	assert.False(t, j.Rollback("search-2"))
+	assert.Equal(t, []string{"chef-node:search-1", "server:search-1", "server:search-2"}, removed)
+
+	// Nothing is bootstrapped, so shared keypair is removed too
+	j.Finish()
+	assert.Equal(t, "keypair:fox", removed[len(removed)-1])
+
+	failed := j.Report()
+	assert.Equal(t, 1, len(failed))
+	assert.Equal(t, "chef-client search-2", failed[0].String())
+}
+
+func TestCommit(t *testing.T) {
+	var removed []string
+	j := newTestJournal(&removed)
+	j.Add(Resource{Kind: KindKeyPair, Name: "fox"})
+	j.Add(Resource{Kind: KindServer, ID: "id-1", Name: "search-1", Host: "search-1"})
+	j.Add(Resource{Kind: KindServer, ID: "id-2", Name: "search-2", Host: "search-2"})
+
+	j.Commit("search-1")
+	j.Keep("search-2")
+	j.Finish()
+	assert.Equal(t, []string(nil), removed)
+	assert.Equal(t, 0, len(j.Report()))
+}
+
+func TestClose(t *testing.T) {
+	var removed []string
+	j := newTestJournal(&removed)
+	j.Add(Resource{Kind: KindServer, ID: "id-1", Name: "search-1", Host: "search-1"})
+
+	j.Close(false)
+	assert.Equal(t, []string{"server:search-1"}, removed)
+
+	// Server created after interrupt is removed at once
+	j.Add(Resource{Kind: KindServer, ID: "id-2", Name: "search-2", Host: "search-2"})
+	assert.Equal(t, []string{"server:search-1", "server:search-2"}, removed)
+}
@@ -0,0 +1,43 @@
+package rollback
+
+import (
+	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+// Resource is created by nodeup and removed on failure.
+// Host is empty for resources shared by all hosts of the run
+type Resource struct {
+	Kind string
+	ID   string
+	Name string
+	Host string
+}
+
+// Remover deletes resources of one kind
+type Remover func(r Resource) error
+
+// Failure is resource which couldn't be removed
+type Failure struct {
+	Resource
+	Err error
+}
+
+// Journal records created resources and removes them in reverse order
+type Journal struct {
+	nodeup   nodeup.NodeUP
+	removers map[string]Remover
+
+	mutex     sync.Mutex
+	pending   []Resource
+	committed map[string]bool
+	kept      []Resource
+	removed   []Resource
+	failed    []Failure
+	// closed journal handles new resources at once
+	closed bool
+	keep   bool
+
+	log *logrus.Entry
+}
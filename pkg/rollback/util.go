@@ -0,0 +1,17 @@
+package rollback
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+func (j *Journal) Log() *logrus.Entry {
+	log := j.nodeup.Log().WithField("context", "rollback")
+	return log
+}
+
+func (r Resource) String() string {
+	if r.ID != "" && r.ID != r.Name {
+		return r.Kind + " " + r.Name + " (" + r.ID + ")"
+	}
+	return r.Kind + " " + r.Name
+}
@@ -0,0 +1,135 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+)
+
+// Authentication methods
+const (
+	AuthAgent = "agent"
+	AuthKey   = "key"
+	AuthCert  = "cert"
+)
+
+// signers collects keys of all methods in configured order.
+// Client tries publickey auth once, so keys are offered in one method.
+// Agent keys sign during handshake, done closes agent connection after it
+func signers(log *logrus.Entry, auth Auth) ([]ssh.Signer, func(), error) {
+	var result []ssh.Signer
+	var conns []io.Closer
+	done := func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	for _, method := range auth.Methods {
+		var signers []ssh.Signer
+		var err error
+		switch method {
+		case AuthAgent:
+			var conn net.Conn
+			signers, conn, err = agentSigners()
+			if conn != nil {
+				conns = append(conns, conn)
+			}
+		case AuthKey:
+			signers, err = keySigners(auth)
+		case AuthCert:
+			signers, err = certSigners(auth)
+		default:
+			done()
+			return nil, nil, fmt.Errorf("unknown ssh auth method %s", method)
+		}
+		if err != nil {
+			log.Debugf("Skip ssh auth method %s: %s", method, err)
+			continue
+		}
+		log.Debugf("Using ssh auth method %s with %d keys", method, len(signers))
+		result = append(result, signers...)
+	}
+	if len(result) == 0 {
+		done()
+		return nil, nil, errors.New("no ssh keys available, check -sshAuth, -sshKeyPath and SSH_AUTH_SOCK")
+	}
+	return result, done, nil
+}
+
+// agentSigners returns agent connection which should be closed after handshake
+func agentSigners() ([]ssh.Signer, net.Conn, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil, errors.New("SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, err
+	}
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return signers, conn, nil
+}
+
+func keySigners(auth Auth) ([]ssh.Signer, error) {
+	signer, err := loadKey(auth)
+	if err != nil {
+		return nil, err
+	}
+	return []ssh.Signer{signer}, nil
+}
+
+func certSigners(auth Auth) ([]ssh.Signer, error) {
+	if auth.CertPath == "" {
+		return nil, errors.New("certificate path is empty")
+	}
+	data, err := ioutil.ReadFile(auth.CertPath)
+	if err != nil {
+		return nil, err
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, err
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a certificate", auth.CertPath)
+	}
+
+	signer, err := loadKey(auth)
+	if err != nil {
+		return nil, err
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, err
+	}
+	return []ssh.Signer{certSigner}, nil
+}
+
+func loadKey(auth Auth) (ssh.Signer, error) {
+	if auth.KeyPath == "" {
+		return nil, errors.New("key path is empty")
+	}
+	data, err := ioutil.ReadFile(auth.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(auth.Passphrase) > 0 {
+		return ssh.ParsePrivateKeyWithPassphrase(data, auth.Passphrase)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		return nil, fmt.Errorf("key %s is encrypted, set SSH_KEY_PASSPHRASE", auth.KeyPath)
+	}
+	return signer, err
+}
@@ -0,0 +1,134 @@
+package ssh
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testNodeUP struct{}
+
+func (testNodeUP) Version() string { return "test" }
+
+func (testNodeUP) Log() *logrus.Entry { return logrus.NewEntry(logrus.New()) }
+
+func writeTestKey(t *testing.T, dir string, passphrase []byte) (string, ssh.Signer) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Equal(t, nil, err)
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if passphrase != nil {
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, passphrase, x509.PEMCipherAES256)
+		assert.Equal(t, nil, err)
+	}
+	path := filepath.Join(dir, "id_rsa")
+	assert.Equal(t, nil, ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600))
+
+	signer, err := ssh.NewSignerFromKey(key)
+	assert.Equal(t, nil, err)
+	return path, signer
+}
+
+func TestSignersKeyPassphrase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeup-ssh")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	keyPath, signer := writeTestKey(t, dir, []byte("secret"))
+	log := testNodeUP{}.Log()
+
+	_, _, err = signers(log, Auth{Methods: []string{AuthKey}, KeyPath: keyPath})
+	assert.EqualError(t, err, "no ssh keys available, check -sshAuth, -sshKeyPath and SSH_AUTH_SOCK")
+
+	keys, done, err := signers(log, Auth{Methods: []string{AuthKey}, KeyPath: keyPath, Passphrase: []byte("secret")})
+	done()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(keys))
+	assert.Equal(t, signer.PublicKey().Marshal(), keys[0].PublicKey().Marshal())
+}
+
+func TestSignersOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeup-ssh")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	keyPath, signer := writeTestKey(t, dir, nil)
+	caDir := filepath.Join(dir, "ca")
+	assert.Equal(t, nil, os.Mkdir(caDir, 0700))
+	_, ca := writeTestKey(t, caDir, nil)
+
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"cloud-user"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	assert.Equal(t, nil, cert.SignCert(rand.Reader, ca))
+	certPath := keyPath + "-cert.pub"
+	assert.Equal(t, nil, ioutil.WriteFile(certPath, ssh.MarshalAuthorizedKey(cert), 0644))
+
+	os.Unsetenv("SSH_AUTH_SOCK")
+	log := testNodeUP{}.Log()
+	keys, done, err := signers(log, Auth{
+		Methods:  []string{AuthAgent, AuthCert, AuthKey},
+		KeyPath:  keyPath,
+		CertPath: certPath,
+	})
+	done()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(keys))
+	assert.Equal(t, ssh.CertAlgoRSAv01, keys[0].PublicKey().Type())
+	assert.Equal(t, ssh.KeyAlgoRSA, keys[1].PublicKey().Type())
+
+	_, _, err = signers(log, Auth{Methods: []string{"password"}})
+	assert.EqualError(t, err, "unknown ssh auth method password")
+}
+
+func TestSignersAgentClosed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeup-ssh")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Equal(t, nil, err)
+	keyring := agent.NewKeyring()
+	assert.Equal(t, nil, keyring.Add(agent.AddedKey{PrivateKey: key}))
+
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.Equal(t, nil, err)
+	defer listener.Close()
+	closed := make(chan struct{})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		agent.ServeAgent(keyring, conn)
+		close(closed)
+	}()
+
+	os.Setenv("SSH_AUTH_SOCK", socket)
+	defer os.Unsetenv("SSH_AUTH_SOCK")
+
+	keys, done, err := signers(testNodeUP{}.Log(), Auth{Methods: []string{AuthAgent}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(keys))
+
+	done()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("agent connection is not closed")
+	}
+}
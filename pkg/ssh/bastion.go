@@ -0,0 +1,147 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strings"
+	"time"
+)
+
+// NewBastion parses chained jump hosts like user@jump1:22,user@jump2
+func NewBastion(nodeup nodeup.NodeUP, spec string, defaultUser string, config Config) (*Bastion, error) {
+	jumps, err := ParseJumps(spec, defaultUser)
+	if err != nil {
+		return nil, err
+	}
+	config.Bastion = nil
+	return &Bastion{
+		nodeup: nodeup,
+		jumps:  jumps,
+		config: config,
+	}, nil
+}
+
+func ParseJumps(spec string, defaultUser string) ([]Jump, error) {
+	var jumps []Jump
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		user, host := defaultUser, item
+		if i := strings.LastIndex(item, "@"); i >= 0 {
+			user, host = item[:i], item[i+1:]
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "22")
+		}
+		if user == "" || strings.HasPrefix(host, ":") {
+			return nil, fmt.Errorf("invalid bastion %s", item)
+		}
+		jumps = append(jumps, Jump{user, host})
+	}
+	if len(jumps) == 0 {
+		return nil, errors.New("bastion list is empty")
+	}
+	return jumps, nil
+}
+
+// Dial connects through the last jump host, without bastion it is net.DialTimeout.
+// Timeout is used for direct connection only
+func (b *Bastion) Dial(network string, address string, timeout time.Duration) (net.Conn, error) {
+	if b == nil {
+		return net.DialTimeout(network, address, timeout)
+	}
+
+	client, err := b.connect()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.Dial(network, address)
+	if err == nil {
+		return conn, nil
+	}
+
+	// Target is unreachable while bastion is alive
+	if _, _, keepaliveErr := client.SendRequest("keepalive@openssh.com", true, nil); keepaliveErr == nil {
+		return nil, err
+	}
+
+	b.Log().Warnf("Bastion connection lost, reconnecting: %s", err)
+	b.reset()
+	client, err = b.connect()
+	if err != nil {
+		return nil, err
+	}
+	return client.Dial(network, address)
+}
+
+func (b *Bastion) connect() (*ssh.Client, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.clients) > 0 {
+		return b.clients[len(b.clients)-1], nil
+	}
+
+	var clients []*ssh.Client
+	for _, jump := range b.jumps {
+		client, err := b.jump(clients, jump)
+		if err != nil {
+			closeClients(clients)
+			return nil, fmt.Errorf("bastion %s: %s", jump.Address, err)
+		}
+		b.Log().Debugf("Connected to bastion %s@%s", jump.User, jump.Address)
+		clients = append(clients, client)
+	}
+	b.clients = clients
+	return clients[len(clients)-1], nil
+}
+
+func (b *Bastion) jump(clients []*ssh.Client, jump Jump) (*ssh.Client, error) {
+	config, done, err := b.config.clientConfig(b.Log(), jump.User)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	var conn net.Conn
+	if len(clients) == 0 {
+		conn, err = net.DialTimeout("tcp", jump.Address, 10*time.Second)
+	} else {
+		conn, err = clients[len(clients)-1].Dial("tcp", jump.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, jump.Address, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func (b *Bastion) reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	closeClients(b.clients)
+	b.clients = nil
+}
+
+// Close disconnects all jump hosts
+func (b *Bastion) Close() {
+	if b != nil {
+		b.reset()
+	}
+}
+
+func closeClients(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
+}
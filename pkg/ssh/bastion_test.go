@@ -0,0 +1,34 @@
+package ssh
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseJumps(t *testing.T) {
+	jumps, err := ParseJumps("ops@jump1.example.com:2222, jump2.example.com", "cloud-user")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Jump{
+		{"ops", "jump1.example.com:2222"},
+		{"cloud-user", "jump2.example.com:22"},
+	}, jumps)
+
+	_, err = ParseJumps("ops@", "cloud-user")
+	assert.EqualError(t, err, "invalid bastion ops@")
+
+	_, err = ParseJumps(" ,", "cloud-user")
+	assert.EqualError(t, err, "bastion list is empty")
+}
+
+func TestDialWithoutBastion(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	defer l.Close()
+
+	var b *Bastion
+	conn, err := b.Dial("tcp", l.Addr().String(), time.Second)
+	assert.Equal(t, nil, err)
+	conn.Close()
+}
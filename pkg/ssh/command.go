@@ -0,0 +1,180 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bytes of stdout and stderr kept in result
+const tailSize = 4096
+
+// Run executes command with timeout and retries, ctx cancels it at any time
+func (s *Ssh) Run(ctx context.Context, command Command) (*Result, error) {
+	if command.Name == "" {
+		command.Name = command.Cmd
+	}
+	result := &Result{
+		Name:     command.Name,
+		ExitCode: -1,
+	}
+
+	var err error
+	for result.Attempts < command.Retries+1 {
+		if result.Attempts > 0 {
+			s.Log().Warnf("Retry %s in %s: %s", command.Name, command.RetryDelay, err)
+			select {
+			case <-ctx.Done():
+				return result, &CommandError{result, ctx.Err()}
+			case <-time.After(command.RetryDelay):
+			}
+		}
+		result.Attempts++
+
+		err = s.runOnce(ctx, command, result)
+		if err == nil {
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return result, &CommandError{result, err}
+}
+
+func (s *Ssh) runOnce(ctx context.Context, command Command, result *Result) error {
+	if command.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, command.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+	result.ExitCode = -1
+
+	session, err := s.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var output io.Writer = ioutil.Discard
+	if command.Output != nil {
+		// stdout and stderr are copied to output from different goroutines
+		output = &lockedWriter{next: command.Output}
+	}
+	stdout := &tailBuffer{size: tailSize}
+	stderr := &tailBuffer{size: tailSize}
+	session.Stdout = io.MultiWriter(output, stdout)
+	session.Stderr = io.MultiWriter(output, stderr)
+	defer func() {
+		result.Stdout = stdout.String()
+		result.Stderr = stderr.String()
+	}()
+
+	s.Log().Debugf("Running %s", command.Cmd)
+	err = session.Start(command.Cmd)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		return ctx.Err()
+	}
+
+	switch e := err.(type) {
+	case nil:
+		result.ExitCode = 0
+	case *ssh.ExitError:
+		result.ExitCode = e.ExitStatus()
+	}
+	if err != nil {
+		s.Log().Errorf("\"%s\" error: %s", command.Cmd, err)
+		return err
+	}
+	s.Log().Debugf("Finished %s in %s", command.Cmd, result.Duration)
+	return nil
+}
+
+// RunCommandPipe runs command without timeout, output is written to outfile
+func (s *Ssh) RunCommandPipe(command string, outfile io.Writer) error {
+	_, err := s.Run(context.Background(), Command{Cmd: command, Output: outfile})
+	return err
+}
+
+func (e *CommandError) Error() string {
+	message := fmt.Sprintf("%s failed after %d attempt(s) with exit code %d: %s",
+		e.Result.Name, e.Result.Attempts, e.Result.ExitCode, e.Err)
+	tail := e.Result.Stderr
+	if strings.TrimSpace(tail) == "" {
+		tail = e.Result.Stdout
+	}
+	if lines := lastLines(tail, 5); lines != "" {
+		message += "\n" + lines
+	}
+	return message
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// tailBuffer keeps last size bytes of output
+type tailBuffer struct {
+	size  int
+	mutex sync.Mutex
+	data  []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.data = append(t.data, p...)
+	if len(t.data) > t.size {
+		t.data = t.data[len(t.data)-t.size:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return string(t.data)
+}
+
+// lockedWriter serializes writes to shared output
+type lockedWriter struct {
+	mutex sync.Mutex
+	next  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.next.Write(p)
+}
+
+func lastLines(text string, count int) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if len(lines) > count {
+		lines = lines[len(lines)-count:]
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,134 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testServer runs exec requests with local shell
+func testServer(t *testing.T) (*Ssh, func()) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Equal(t, nil, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	assert.Equal(t, nil, err)
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestConn(conn, config)
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "cloud-user",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	assert.Equal(t, nil, err)
+
+	s := &Ssh{nodeup: testNodeUP{}, client: client}
+	return s, func() {
+		s.Close()
+		l.Close()
+	}
+}
+
+func serveTestConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go serveTestSession(channel, requests)
+	}
+}
+
+func serveTestSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	var cmd *exec.Cmd
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			ssh.Unmarshal(req.Payload, &payload)
+			cmd = exec.Command("sh", "-c", payload.Command)
+			cmd.Stdout = channel
+			cmd.Stderr = channel.Stderr()
+			req.Reply(cmd.Start() == nil, nil)
+			go func(cmd *exec.Cmd) {
+				status := 0
+				if err := cmd.Wait(); err != nil {
+					status = cmd.ProcessState.Sys().(syscall.WaitStatus).ExitStatus()
+				}
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+				channel.Close()
+			}(cmd)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+	// Client closed session
+	if cmd != nil && cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
+
+func TestRun(t *testing.T) {
+	s, stop := testServer(t)
+	defer stop()
+
+	var out bytes.Buffer
+	result, err := s.Run(context.Background(), Command{Name: "hello", Cmd: "echo hello; echo oops >&2", Output: &out})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, result.ExitCode)
+	assert.Equal(t, 1, result.Attempts)
+	assert.Equal(t, "hello\n", result.Stdout)
+	assert.Equal(t, "oops\n", result.Stderr)
+	assert.Contains(t, out.String(), "hello\n")
+}
+
+func TestRunRetries(t *testing.T) {
+	s, stop := testServer(t)
+	defer stop()
+
+	result, err := s.Run(context.Background(), Command{Name: "packages", Cmd: "echo 'E: Unable to locate package' >&2; exit 100", Retries: 2})
+	assert.EqualError(t, err, "packages failed after 3 attempt(s) with exit code 100: Process exited with status 100\nE: Unable to locate package")
+	assert.Equal(t, 100, result.ExitCode)
+	assert.Equal(t, 3, result.Attempts)
+}
+
+func TestRunTimeout(t *testing.T) {
+	s, stop := testServer(t)
+	defer stop()
+
+	start := time.Now()
+	result, err := s.Run(context.Background(), Command{Name: "chef-client", Cmd: "sleep 10", Timeout: 200 * time.Millisecond})
+	assert.EqualError(t, err, "chef-client failed after 1 attempt(s) with exit code -1: context deadline exceeded")
+	assert.Equal(t, -1, result.ExitCode)
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.Run(ctx, Command{Cmd: "sleep 10", Retries: 3})
+	assert.EqualError(t, err, "sleep 10 failed after 1 attempt(s) with exit code -1: context canceled")
+}
@@ -0,0 +1,167 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Host key policies
+const (
+	// PolicyStrict accepts only keys from known_hosts
+	PolicyStrict = "strict"
+	// PolicyAcceptNew saves key of unknown host, changed key is rejected
+	PolicyAcceptNew = "accept-new"
+	// PolicyInsecure doesn't check host keys
+	PolicyInsecure = "insecure"
+)
+
+const (
+	consoleKeysBegin = "-----BEGIN SSH HOST KEY KEYS-----"
+	consoleKeysEnd   = "-----END SSH HOST KEY KEYS-----"
+)
+
+// known_hosts is shared by bootstrap goroutines
+var knownHostsMutex sync.Mutex
+
+func (h HostKeys) callback() (ssh.HostKeyCallback, error) {
+	switch h.Policy {
+	case PolicyInsecure:
+		return ssh.InsecureIgnoreHostKey(), nil
+	case PolicyStrict, PolicyAcceptNew:
+	default:
+		return nil, fmt.Errorf("unknown host key policy %s", h.Policy)
+	}
+
+	if err := createKnownHosts(h.KnownHosts); err != nil {
+		return nil, err
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsMutex.Lock()
+		defer knownHostsMutex.Unlock()
+
+		// Read file on every connect, keys could be pinned after start
+		check, err := knownhosts.New(h.KnownHosts)
+		if err != nil {
+			return err
+		}
+		err = check(hostname, remote, key)
+
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 && h.Policy == PolicyAcceptNew {
+			return appendKnownHost(h.KnownHosts, hostname, key)
+		}
+		return err
+	}, nil
+}
+
+// Pin replaces address keys in known_hosts
+func Pin(path string, address string, keys []ssh.PublicKey) error {
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+
+	if err := createKnownHosts(path); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	host := knownhosts.Normalize(address)
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || containsHost(line, host) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	for _, key := range keys {
+		lines = append(lines, knownhosts.Line([]string{host}, key))
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+// ConsoleHostKeys returns host keys printed by cloud-init to console log
+func ConsoleHostKeys(console string) []ssh.PublicKey {
+	var keys []ssh.PublicKey
+	inside := false
+	for _, line := range strings.Split(console, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasSuffix(line, consoleKeysBegin) {
+			inside = true
+			keys = nil
+			continue
+		}
+		if strings.HasSuffix(line, consoleKeysEnd) {
+			inside = false
+			continue
+		}
+		if !inside {
+			continue
+		}
+		// Skip console prefix like timestamp
+		fields := strings.Fields(line)
+		for i := range fields {
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(fields[i:], " ")))
+			if err == nil {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	return keys
+}
+
+func containsHost(line string, host string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return false
+	}
+	hosts := fields[0]
+	if strings.HasPrefix(hosts, "@") && len(fields) > 1 {
+		hosts = fields[1]
+	}
+	for _, h := range strings.Split(hosts, ",") {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+func appendKnownHost(path string, hostname string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n")
+	return err
+}
+
+func createKnownHosts(path string) error {
+	if path == "" {
+		return errors.New("known_hosts path is empty")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
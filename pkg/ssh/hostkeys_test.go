@@ -0,0 +1,79 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Equal(t, nil, err)
+	key, err := ssh.NewPublicKey(pub)
+	assert.Equal(t, nil, err)
+	return key
+}
+
+func testKnownHosts(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "nodeup-ssh")
+	assert.Equal(t, nil, err)
+	return filepath.Join(dir, "known_hosts"), func() { os.RemoveAll(dir) }
+}
+
+func TestConsoleHostKeys(t *testing.T) {
+	key := testKey(t)
+	console := strings.Join([]string{
+		"[   20.103021] cloud-init[1021]: Cloud-init v. 19.4 running 'modules:final'",
+		"-----BEGIN SSH HOST KEY FINGERPRINTS-----",
+		"256 SHA256:" + "abc root@search-ab12c (ED25519)",
+		"-----END SSH HOST KEY FINGERPRINTS-----",
+		"-----BEGIN SSH HOST KEY KEYS-----",
+		"[   20.210211] " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + " root@search-ab12c\r",
+		"-----END SSH HOST KEY KEYS-----",
+	}, "\n")
+
+	keys := ConsoleHostKeys(console)
+	assert.Equal(t, 1, len(keys))
+	assert.Equal(t, ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(keys[0]))
+
+	assert.Equal(t, 0, len(ConsoleHostKeys("login:")))
+}
+
+func TestHostKeysAcceptNew(t *testing.T) {
+	path, cleanup := testKnownHosts(t)
+	defer cleanup()
+
+	callback, err := HostKeys{Policy: PolicyAcceptNew, KnownHosts: path}.callback()
+	assert.Equal(t, nil, err)
+
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 22}
+	key := testKey(t)
+	assert.Equal(t, nil, callback("10.0.0.5:22", remote, key))
+	assert.Equal(t, nil, callback("10.0.0.5:22", remote, key))
+	assert.NotEqual(t, nil, callback("10.0.0.5:22", remote, testKey(t)))
+}
+
+func TestHostKeysStrictPin(t *testing.T) {
+	path, cleanup := testKnownHosts(t)
+	defer cleanup()
+
+	callback, err := HostKeys{Policy: PolicyStrict, KnownHosts: path}.callback()
+	assert.Equal(t, nil, err)
+
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 22}
+	oldKey, key := testKey(t), testKey(t)
+	assert.NotEqual(t, nil, callback("10.0.0.5:22", remote, key))
+
+	// Address is reused by new server
+	assert.Equal(t, nil, Pin(path, "10.0.0.5", []ssh.PublicKey{oldKey}))
+	assert.Equal(t, nil, Pin(path, "10.0.0.5", []ssh.PublicKey{key}))
+	assert.Equal(t, nil, callback("10.0.0.5:22", remote, key))
+	assert.NotEqual(t, nil, callback("10.0.0.5:22", remote, oldKey))
+}
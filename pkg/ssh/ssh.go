@@ -2,49 +2,26 @@ package ssh
 
 import (
 	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
-	"github.com/pkg/sftp"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/agent"
-	"io"
-	"net"
-	"os"
-	"os/exec"
 )
 
-func New(nodeup nodeup.NodeUP, address string, user string) (*Ssh, error) {
+func New(nodeup nodeup.NodeUP, address string, user string, config Config) (*Ssh, error) {
 	s := &Ssh{
 		nodeup: nodeup,
 		client: nil,
 	}
 
-	if len(os.Getenv("SSH_AUTH_SOCK")) == 0 {
-		cmd := exec.Command("ssh-agent")
-		err := cmd.Run()
-		if err != nil {
-			return s, err
-		}
-	}
-
-	socket := os.Getenv("SSH_AUTH_SOCK")
-
-	conn, err := net.Dial("unix", socket)
+	sshConfig, done, err := config.clientConfig(s.Log(), user)
 	if err != nil {
 		return s, err
 	}
-	s.Log().Debugf("Using SSH Agent with socket %s", socket)
-
-	agentClient := agent.NewClient(conn)
-
-	sshConfig := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeysCallback(agentClient.Signers),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	defer done()
+	if config.HostKeys.Policy == PolicyInsecure {
+		s.Log().Warnf("Host key for %s is not verified", address)
 	}
 
-	client, err := ssh.Dial("tcp", address+":22", sshConfig)
+	client, err := dial(config.Bastion, address+":22", sshConfig)
 	if err != nil {
 		return s, err
 	}
@@ -54,59 +31,52 @@ func New(nodeup nodeup.NodeUP, address string, user string) (*Ssh, error) {
 	return s, err
 }
 
-func (o *Ssh) Log() *logrus.Entry {
-	log := o.nodeup.Log().WithField("context", "ssh")
-	return log
-}
-
-func (s *Ssh) sshSession() (*ssh.Session, error) {
-	session, err := s.client.NewSession()
+// clientConfig returns done which should be called after handshake
+func (c Config) clientConfig(log *logrus.Entry, user string) (*ssh.ClientConfig, func(), error) {
+	signers, done, err := signers(log, c.Auth)
 	if err != nil {
-		return session, err
+		return nil, nil, err
 	}
-	return session, err
+	hostKeyCallback, err := c.HostKeys.callback()
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+	return &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signers...),
+		},
+		HostKeyCallback: hostKeyCallback,
+	}, done, nil
 }
 
-func (s *Ssh) RunCommandPipe(command string, outfile *os.File) error {
-
-	session, err := s.sshSession()
+// dial connects directly or through bastion
+func dial(bastion *Bastion, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := bastion.Dial("tcp", address, 0)
 	if err != nil {
-		s.Log().Errorf("session error: %s", err)
+		return nil, err
 	}
-	defer session.Close()
-
-	s.Log().Debugf("Writing bootstrap output to file %s", outfile.Name())
-
-	session.Stdout = io.MultiWriter(outfile)
-	session.Stderr = session.Stdout
-
-	s.Log().Debugf("Running %s", command)
-	if err := session.Run(command); err != nil {
-		s.Log().Errorf("\"%s\" error: %s", command, err)
-		return err
+	c, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
 	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
 
-	s.Log().Debugf("Finished %s", command)
-
+// Close closes SFTP session and connection
+func (s *Ssh) Close() error {
+	if s.sftp != nil {
+		s.sftp.Close()
+	}
+	if s.client != nil {
+		return s.client.Close()
+	}
 	return nil
 }
 
-func (s *Ssh) TransferFile(data []byte, name string, path string) error {
-	s.Log().Debugf("Starting transferring file %s", path+"/"+name)
-
-	sftp, err := sftp.NewClient(s.client)
-	s.assertError(err)
-
-	f, err := sftp.Create(name)
-	s.assertError(err)
-
-	_, err = f.Write(data)
-	s.assertError(err)
-
-	_, err = sftp.Lstat(name)
-	s.assertError(err)
-
-	s.Log().Debugf("Finished transferring file %s", path+"/"+name)
-
-	return err
+func (o *Ssh) Log() *logrus.Entry {
+	log := o.nodeup.Log().WithField("context", "ssh")
+	return log
 }
@@ -0,0 +1,133 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"github.com/pkg/sftp"
+	"io"
+	"os"
+	"path"
+	"sync"
+)
+
+// Upload transfers files to dir in parallel over one SFTP session
+func (s *Ssh) Upload(dir string, files ...File) error {
+	client, err := s.sftpClient()
+	if err != nil {
+		return err
+	}
+
+	errs := make(chan error, len(files))
+	var wg sync.WaitGroup
+	for _, f := range files {
+		wg.Add(1)
+		go func(f File) {
+			defer wg.Done()
+			target := path.Join(dir, f.Name)
+			s.Log().Debugf("Starting transferring file %s", target)
+			err := uploadFile(client, dir, f)
+			if err != nil {
+				s.Log().Errorf("Transferring file %s error: %s", target, err)
+				errs <- fmt.Errorf("upload %s: %s", target, err)
+				return
+			}
+			s.Log().Debugf("Finished transferring file %s", target)
+		}(f)
+	}
+	wg.Wait()
+	close(errs)
+
+	// First error or nil
+	return <-errs
+}
+
+func (s *Ssh) TransferFile(data []byte, name string, path string) error {
+	return s.Upload(path, File{Name: name, Data: data, Mode: 0644})
+}
+
+func (s *Ssh) sftpClient() (*sftp.Client, error) {
+	s.sftpMutex.Lock()
+	defer s.sftpMutex.Unlock()
+
+	if s.sftp != nil {
+		return s.sftp, nil
+	}
+	client, err := sftp.NewClient(s.client)
+	if err != nil {
+		return nil, err
+	}
+	s.sftp = client
+	return client, nil
+}
+
+// uploadFile writes temp file with mode, checks it and renames to target
+func uploadFile(client *sftp.Client, dir string, f File) error {
+	target := path.Join(dir, f.Name)
+	tmp := path.Join(dir, "."+f.Name+".nodeup")
+
+	err := writeFile(client, tmp, f)
+	if err == nil {
+		err = verifyFile(client, tmp, f.Data)
+	}
+	if err == nil {
+		err = renameFile(client, tmp, target)
+	}
+	if err != nil {
+		client.Remove(tmp)
+	}
+	return err
+}
+
+func writeFile(client *sftp.Client, name string, f File) error {
+	w, err := client.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	// Mode is set before data, so keys are never readable by others
+	err = w.Chmod(f.Mode)
+	if err != nil {
+		return err
+	}
+	if f.Owner != nil {
+		err = client.Chown(name, f.Owner.UID, f.Owner.GID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(f.Data)
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func verifyFile(client *sftp.Client, name string, data []byte) error {
+	r, err := client.Open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+	expected := sha256.Sum256(data)
+	if !bytes.Equal(h.Sum(nil), expected[:]) {
+		return fmt.Errorf("sha256 mismatch: expected %x, got %x", expected, h.Sum(nil))
+	}
+	return nil
+}
+
+func renameFile(client *sftp.Client, from string, to string) error {
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		return client.PosixRename(from, to)
+	}
+	// Plain SFTP rename fails if target exists
+	client.Remove(to)
+	return client.Rename(from, to)
+}
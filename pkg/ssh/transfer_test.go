@@ -0,0 +1,70 @@
+package ssh
+
+import (
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testSftp returns client connected to in-process server with local filesystem
+func testSftp(t *testing.T) *sftp.Client {
+	serverReader, clientWriter := io.Pipe()
+	clientReader, serverWriter := io.Pipe()
+
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter})
+	assert.Equal(t, nil, err)
+	go func() {
+		server.Serve()
+		serverWriter.Close()
+	}()
+
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+	assert.Equal(t, nil, err)
+	return client
+}
+
+func TestUploadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeup-sftp")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	client := testSftp(t)
+	defer client.Close()
+
+	assert.Equal(t, nil, ioutil.WriteFile(filepath.Join(dir, "validation.pem"), []byte("old"), 0644))
+	assert.Equal(t, nil, uploadFile(client, dir, File{Name: "validation.pem", Data: []byte("key"), Mode: 0600}))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "validation.pem"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "key", string(data))
+
+	info, err := os.Stat(filepath.Join(dir, "validation.pem"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	_, err = os.Stat(filepath.Join(dir, ".validation.pem.nodeup"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestUploadFileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodeup-sftp")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	client := testSftp(t)
+	defer client.Close()
+
+	err = uploadFile(client, filepath.Join(dir, "missing"), File{Name: "hosts", Data: []byte("127.0.0.1"), Mode: 0644})
+	assert.NotEqual(t, nil, err)
+
+	assert.Equal(t, nil, ioutil.WriteFile(filepath.Join(dir, "hosts"), []byte("127.0.0.1"), 0644))
+	assert.EqualError(t, verifyFile(client, filepath.Join(dir, "hosts"), []byte("10.0.0.1")),
+		"sha256 mismatch: expected f5047344122f0dee9974ba6761e61c6b8649e1f3968d13a635ebbf7be53a3a0d, got 12ca17b49af2289436f303e0166030a21e525d266e209267433801a8fd4071a0")
+}
@@ -2,13 +2,104 @@ package ssh
 
 import (
 	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+	"github.com/pkg/sftp"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"sync"
+	"time"
 )
 
 type Ssh struct {
 	nodeup nodeup.NodeUP
 	client *ssh.Client
 
+	sftp      *sftp.Client
+	sftpMutex sync.Mutex
+
 	log *logrus.Entry
 }
+
+// File is uploaded with mode and optional owner
+type File struct {
+	Name string
+	Data []byte
+	Mode os.FileMode
+	// Owner is changed when set, login user must be root
+	Owner *Owner
+}
+
+type Owner struct {
+	UID int
+	GID int
+}
+
+// Command is remote command with timeout and retry policy
+type Command struct {
+	// Name is step name for logs and errors, default is Cmd
+	Name string
+	Cmd  string
+	// Timeout for one attempt, zero is no timeout
+	Timeout    time.Duration
+	Retries    int
+	RetryDelay time.Duration
+	// Output gets full stdout and stderr
+	Output io.Writer
+}
+
+// Result describes the last command attempt
+type Result struct {
+	Name     string
+	ExitCode int
+	Attempts int
+	Duration time.Duration
+	// Stdout and Stderr are output tails
+	Stdout string
+	Stderr string
+}
+
+// CommandError keeps result of failed command
+type CommandError struct {
+	Result *Result
+	Err    error
+}
+
+// Config is SSH connection settings
+type Config struct {
+	Auth     Auth
+	HostKeys HostKeys
+	// Bastion is nil for direct connection
+	Bastion *Bastion
+}
+
+// Auth lists SSH authentication methods tried in order
+type Auth struct {
+	Methods []string
+	// KeyPath is private key for key and cert methods
+	KeyPath    string
+	Passphrase []byte
+	CertPath   string
+}
+
+// HostKeys is known_hosts file and policy for unknown hosts
+type HostKeys struct {
+	Policy     string
+	KnownHosts string
+}
+
+// Jump is bastion host like user@host:port
+type Jump struct {
+	User    string
+	Address string
+}
+
+// Bastion keeps connection through chained jump hosts
+type Bastion struct {
+	nodeup nodeup.NodeUP
+	jumps  []Jump
+	config Config
+
+	mutex   sync.Mutex
+	clients []*ssh.Client
+}
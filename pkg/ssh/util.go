@@ -1,7 +1,9 @@
 package ssh
 
-func (s *Ssh) assertError(err error) {
-	if err != nil {
-		s.Log().Error(err)
-	}
+import (
+	"github.com/sirupsen/logrus"
+)
+
+func (b *Bastion) Log() *logrus.Entry {
+	return b.nodeup.Log().WithField("context", "ssh").WithField("bastion", b.jumps[len(b.jumps)-1].Address)
 }
@@ -0,0 +1,31 @@
+package verify
+
+import (
+	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+	"github.com/sirupsen/logrus"
+	"net"
+	"time"
+)
+
+// Dialer connects to host directly or through bastion
+type Dialer func(network string, address string, timeout time.Duration) (net.Conn, error)
+
+// Config describes checks after bootstrap
+type Config struct {
+	// TCP ports which should accept connections
+	TCP []string
+	// HTTP URLs, empty host is replaced by server address
+	HTTP []string
+	// Command should exit zero on host
+	Command string
+	// Timeout for all checks, failed check is repeated until it
+	Timeout time.Duration
+}
+
+type Verify struct {
+	nodeup nodeup.NodeUP
+	config Config
+	dial   Dialer
+
+	log *logrus.Entry
+}
@@ -0,0 +1,10 @@
+package verify
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+func (v *Verify) Log() *logrus.Entry {
+	log := v.nodeup.Log().WithField("context", "verify")
+	return log
+}
@@ -0,0 +1,160 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/onetwotrip/nodeup/pkg/nodeup_const"
+	"github.com/onetwotrip/nodeup/pkg/ssh"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Delay between attempts of failed check
+var checkInterval = 10 * time.Second
+
+func New(nodeup nodeup.NodeUP, config Config, dial Dialer) *Verify {
+	return &Verify{
+		nodeup: nodeup,
+		config: config,
+		dial:   dial,
+	}
+}
+
+// Host runs TCP, HTTP and command checks against server address.
+// SSH client is needed for command check only
+func (v *Verify) Host(ctx context.Context, address string, s *ssh.Ssh) error {
+	deadline := time.Now().Add(v.config.Timeout)
+
+	for _, port := range v.config.TCP {
+		port := port
+		err := v.retry(ctx, deadline, "tcp "+port, func() error {
+			return v.tcp(ctx, address, port)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, rawurl := range v.config.HTTP {
+		rawurl := rawurl
+		err := v.retry(ctx, deadline, "http "+rawurl, func() error {
+			return v.http(ctx, address, rawurl)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if v.config.Command == "" {
+		return nil
+	}
+	if s == nil {
+		return errors.New("verify command needs SSH connection")
+	}
+	return v.retry(ctx, deadline, "command", func() error {
+		// Zero timeout means no limit for ssh, so command isn't started after deadline
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return fmt.Errorf("verify timeout %s is over", v.config.Timeout)
+		}
+		_, err := s.Run(ctx, ssh.Command{
+			Name:    "verify",
+			Cmd:     v.config.Command,
+			Timeout: timeout,
+		})
+		return err
+	})
+}
+
+// retry repeats check until it passes or deadline is reached
+func (v *Verify) retry(ctx context.Context, deadline time.Time, name string, check func() error) error {
+	for {
+		err := check()
+		if err == nil {
+			v.Log().Debugf("Check %s passed", name)
+			return nil
+		}
+		if time.Now().Add(checkInterval).After(deadline) {
+			return fmt.Errorf("check %s failed: %s", name, err)
+		}
+		v.Log().Debugf("Check %s failed: %s", name, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(checkInterval):
+		}
+	}
+}
+
+func (v *Verify) tcp(ctx context.Context, address string, port string) error {
+	conn, err := v.dialContext(ctx, "tcp", net.JoinHostPort(address, port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (v *Verify) http(ctx context.Context, address string, rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	if u.Hostname() == "" {
+		port := u.Port()
+		u.Host = address
+		if port != "" {
+			u.Host = net.JoinHostPort(address, port)
+		}
+	}
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			DialContext:       v.dialContext,
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s returned %s", u, resp.Status)
+	}
+	return nil
+}
+
+// dialContext stops waiting for connection when ctx is cancelled, late connection is closed
+func (v *Verify) dialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan dialed, 1)
+	go func() {
+		conn, err := v.dial(network, address, 5*time.Second)
+		done <- dialed{conn, err}
+	}()
+
+	select {
+	case d := <-done:
+		return d.conn, d.err
+	case <-ctx.Done():
+		go func() {
+			if d := <-done; d.conn != nil {
+				d.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
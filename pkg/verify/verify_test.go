@@ -0,0 +1,79 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"github.com/onetwotrip/nodeup/pkg/ssh"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type testNodeUP struct{}
+
+func (testNodeUP) Version() string    { return "test" }
+func (testNodeUP) Log() *logrus.Entry { return logrus.NewEntry(logrus.New()) }
+
+func TestHost(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	v := New(testNodeUP{}, Config{
+		TCP:  []string{port},
+		HTTP: []string{"http://:" + port + "/health"},
+	}, net.DialTimeout)
+	assert.Equal(t, nil, v.Host(context.Background(), "127.0.0.1", nil))
+
+	v = New(testNodeUP{}, Config{
+		HTTP: []string{"http://:" + port + "/status"},
+	}, net.DialTimeout)
+	assert.EqualError(t, v.Host(context.Background(), "127.0.0.1", nil), "check http http://:"+port+"/status failed: http://127.0.0.1:"+port+"/status returned 503 Service Unavailable")
+}
+
+func TestHostRetry(t *testing.T) {
+	checkInterval = 10 * time.Millisecond
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	v := New(testNodeUP{}, Config{
+		TCP:     []string{port},
+		Timeout: 100 * time.Millisecond,
+	}, net.DialTimeout)
+	assert.Contains(t, v.Host(context.Background(), "127.0.0.1", nil).Error(), "check tcp "+port+" failed")
+
+	v = New(testNodeUP{}, Config{Command: "true"}, net.DialTimeout)
+	assert.EqualError(t, v.Host(context.Background(), "127.0.0.1", nil), "verify command needs SSH connection")
+}
+
+func TestHostDeadline(t *testing.T) {
+	// Command isn't started without time left, ssh would run it without limit
+	v := New(testNodeUP{}, Config{Command: "sleep 600"}, net.DialTimeout)
+	assert.EqualError(t, v.Host(context.Background(), "127.0.0.1", &ssh.Ssh{}), "check command failed: verify timeout 0s is over")
+
+	// Cancelled context aborts hanging dial of HTTP check
+	dial := func(network string, address string, timeout time.Duration) (net.Conn, error) {
+		time.Sleep(time.Second)
+		return nil, errors.New("dial timeout")
+	}
+	v = New(testNodeUP{}, Config{
+		HTTP:    []string{"http://:8080/health"},
+		Timeout: time.Minute,
+	}, dial)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	assert.Equal(t, context.DeadlineExceeded, v.Host(ctx, "127.0.0.1", nil))
+	assert.True(t, time.Since(started) < 500*time.Millisecond)
+}